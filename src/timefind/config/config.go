@@ -24,6 +24,27 @@ type Configuration struct {
 	Exclude  []string
 	Type     string
 	Alias    []string
+	Archives bool              // Index the members of tar and zip files individually
+	Options  map[string]string // Processor specific options
+
+	// Also compare a hash of the start and end of each data file, to notice
+	// files rewritten without changing their size or modification time.
+	Fingerprint bool
+
+	IndexFormat string // "csv" (default) or "binary"
+
+	// If set, e.g. "1m", record which slots of this size each data file
+	// has records in, so that searches can skip files with gaps.
+	Coverage string
+
+	// If set, record a checkpoint about every this many bytes of each data
+	// file (for processors that support them), so that readers can skip
+	// straight to a given time.
+	Checkpoints int64
+
+	// The time zone of timestamps without one in the data files, e.g.
+	// "America/Los_Angeles" or "Local" (default UTC).
+	Timezone string
 }
 
 func NewConfiguration(path string) (*Configuration, error) {
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"timefind/index"
+	"timefind/processor"
+	tf_time "timefind/time"
+)
+
+/*
+  --exec runs a command for every matching file, and --exec-batch for batches
+  of them, instead of listing their paths. In the command,
+
+    {}          is the path of the file (with --exec-batch, it must be a word
+                of its own, and is replaced by the paths of every file in the
+                batch; without one, the paths go at the end, as with xargs)
+    {source}    is the name of the file's source
+    {earliest}  is the time of the earliest record (of any file in the batch)
+    {latest}    is the time of the latest record (of any file in the batch)
+
+  Times are Unix timestamps, like --times outputs, or empty for files with no
+  records. The command is split into
+  words the same way as the exec processor's, and isn't run by a shell, so
+  paths are passed on intact whatever characters they contain.
+*/
+
+// maxBatchBytes limits the total length of the paths given to one command, to
+// stay well clear of the system's limit on the size of a command line.
+const maxBatchBytes = 128 * 1024
+
+// execFailed is the exit status if any of the commands failed, as with xargs.
+const execFailed = 123
+
+// An execBatch collects the files of one source for --exec-batch.
+type execBatch struct {
+	source string
+	paths  []string
+	size   int           // Total length of paths
+	period tf_time.Times // Spanning all the files with records
+}
+
+// An execJob is a command to run, and what it's for (for error messages).
+type execJob struct {
+	args []string
+	what string
+}
+
+type executor struct {
+	command  []string
+	batch    bool
+	parallel int
+	commands []execJob
+	pending  *execBatch
+}
+
+func newExecutor(command string, batch bool, parallel int) (*executor, error) {
+	args, err := processor.SplitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no command given to run")
+	}
+	if batch {
+		for _, arg := range args {
+			if arg != "{}" && strings.Contains(arg, "{}") {
+				return nil, fmt.Errorf("with --exec-batch, {} must be a word of its own: %q", arg)
+			}
+		}
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	return &executor{command: args, batch: batch, parallel: parallel}, nil
+}
+
+// add adds a command for entry, or adds entry to the current batch.
+func (e *executor) add(source string, entry index.Entry) {
+	if !e.batch {
+		e.commands = append(e.commands, execJob{
+			e.expand(source, []string{entry.Path}, entry.Period), entry.Path})
+		return
+	}
+
+	b := e.pending
+	if b != nil && (b.source != source || b.size+len(entry.Path)+1 > maxBatchBytes) {
+		e.flush()
+		b = nil
+	}
+	if b == nil {
+		b = &execBatch{source: source}
+		e.pending = b
+	}
+	b.paths = append(b.paths, entry.Path)
+	b.size += len(entry.Path) + 1
+	if !entry.Period.Earliest.IsZero() || !entry.Period.Latest.IsZero() {
+		b.period.Union(entry.Period)
+	}
+}
+
+// flush adds a command for the current batch.
+func (e *executor) flush() {
+	if b := e.pending; b != nil {
+		e.commands = append(e.commands, execJob{
+			e.expand(b.source, b.paths, b.period),
+			fmt.Sprintf("%d files of %s", len(b.paths), b.source)})
+		e.pending = nil
+	}
+}
+
+// expand fills in the placeholders in the command.
+func (e *executor) expand(source string, paths []string, period tf_time.Times) []string {
+	r := strings.NewReplacer(
+		"{}", paths[0],
+		"{source}", source,
+		"{earliest}", formatUnixTime(period.Earliest),
+		"{latest}", formatUnixTime(period.Latest))
+
+	args := []string{}
+	batched := false
+	for _, arg := range e.command {
+		if arg == "{}" && e.batch {
+			args = append(args, paths...)
+			batched = true
+		} else {
+			args = append(args, r.Replace(arg))
+		}
+	}
+	if e.batch && !batched {
+		args = append(args, paths...)
+	}
+	return args
+}
+
+// finish runs the commands, and returns the exit status for timefind: 0 if
+// they all succeeded, or execFailed if any of them failed.
+func (e *executor) finish() int {
+	if failed := e.run(); failed > 0 {
+		log.Printf("%d of %d commands failed", failed, len(e.commands))
+		return execFailed
+	}
+	return 0
+}
+
+// run runs the commands, e.parallel at a time, and returns how many failed.
+func (e *executor) run() (failed int) {
+	e.flush()
+
+	work := make(chan execJob)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for i := 0; i < e.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				cmd := exec.Command(c.args[0], c.args[1:]...)
+				cmd.Stdout = os.Stdout
+				cmd.Stderr = os.Stderr
+				if err := cmd.Run(); err != nil {
+					log.Printf("%s for %s: %s", c.args[0], c.what, err)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, c := range e.commands {
+		work <- c
+	}
+	close(work)
+	wg.Wait()
+
+	return failed
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"timefind/index"
+	"timefind/internal/fixture"
+	tf_time "timefind/time"
+)
+
+func execEntry(path string, earliest, latest int64) index.Entry {
+	return index.Entry{Path: path,
+		Period: tf_time.Times{Earliest: time.Unix(earliest, 0), Latest: time.Unix(latest, 0)}}
+}
+
+func TestExecExpand(t *testing.T) {
+	tests := []struct {
+		command string
+		batch   bool
+		want    [][]string
+	}{
+		{"echo {} {source}", false, [][]string{
+			{"echo", "/data/a b", "dns"},
+			{"echo", "/data/c", "dns"},
+			{"echo", "/data/d", "http"},
+		}},
+		{"echo file={} from={earliest} to={latest}", false, [][]string{
+			{"echo", "file=/data/a b", "from=100.000000000", "to=200.000000000"},
+			{"echo", "file=/data/c", "from=50.000000000", "to=300.000000000"},
+			{"echo", "file=/data/d", "from=10.000000000", "to=20.000000000"},
+		}},
+		{"echo {source} {} {earliest}-{latest}", true, [][]string{
+			{"echo", "dns", "/data/a b", "/data/c", "50.000000000-300.000000000"},
+			{"echo", "http", "/data/d", "10.000000000-20.000000000"},
+		}},
+		// Without a {}, the batch goes at the end.
+		{"echo {source}", true, [][]string{
+			{"echo", "dns", "/data/a b", "/data/c"},
+			{"echo", "http", "/data/d"},
+		}},
+	}
+
+	for _, test := range tests {
+		e, err := newExecutor(test.command, test.batch, 1)
+		if err != nil {
+			t.Fatalf("%q: %s", test.command, err)
+		}
+		e.add("dns", execEntry("/data/a b", 100, 200))
+		e.add("dns", execEntry("/data/c", 50, 300))
+		e.add("http", execEntry("/data/d", 10, 20))
+		e.flush()
+
+		got := [][]string{}
+		for _, c := range e.commands {
+			got = append(got, c.args)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q (batch %v): got %q, want %q", test.command, test.batch, got, test.want)
+		}
+	}
+}
+
+func TestExecNoRecords(t *testing.T) {
+	tests := []struct {
+		command string
+		batch   bool
+		want    [][]string
+	}{
+		{"echo {} {earliest} {latest}", false, [][]string{
+			{"echo", "/data/empty", "", ""},
+			{"echo", "/data/a", "100.000000000", "200.000000000"},
+		}},
+		// The batch spans just the file with records.
+		{"echo {earliest} {latest} {}", true, [][]string{
+			{"echo", "100.000000000", "200.000000000", "/data/empty", "/data/a"},
+		}},
+	}
+
+	for _, test := range tests {
+		e, err := newExecutor(test.command, test.batch, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.add("dns", index.Entry{Path: "/data/empty"})
+		e.add("dns", execEntry("/data/a", 100, 200))
+		e.flush()
+
+		got := [][]string{}
+		for _, c := range e.commands {
+			got = append(got, c.args)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q (batch %v): got %q, want %q", test.command, test.batch, got, test.want)
+		}
+	}
+}
+
+func TestExecBatchSize(t *testing.T) {
+	e, err := newExecutor("echo {}", true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/" + strings.Repeat("x", 1022)
+	n := 3 * maxBatchBytes / (len(path) + 1)
+	for i := 0; i < n; i++ {
+		e.add("dns", execEntry(path, 0, 0))
+	}
+	e.flush()
+
+	if len(e.commands) != 3 {
+		t.Errorf("%d paths made %d commands, want 3", n, len(e.commands))
+	}
+	total := 0
+	for _, c := range e.commands {
+		size := 0
+		for _, arg := range c.args[1:] {
+			size += len(arg) + 1
+		}
+		if size > maxBatchBytes {
+			t.Errorf("command has %d bytes of paths, more than %d", size, maxBatchBytes)
+		}
+		total += len(c.args) - 1
+	}
+	if total != n {
+		t.Errorf("commands have %d paths, want %d", total, n)
+	}
+}
+
+func TestNewExecutor(t *testing.T) {
+	tests := []struct {
+		command string
+		batch   bool
+		ok      bool
+	}{
+		{"echo {}", false, true},
+		{"echo {}", true, true},
+		{"echo --file={}", false, true},
+		{"echo --file={}", true, false},
+		{"echo {source}", true, true},
+		{"", false, false},
+		{"echo 'unterminated", false, false},
+	}
+
+	for _, test := range tests {
+		_, err := newExecutor(test.command, test.batch, 1)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%q (batch %v): got error %v", test.command, test.batch, err)
+		}
+	}
+}
+
+func TestExecFinish(t *testing.T) {
+	dir := t.TempDir()
+	ok := filepath.Join(dir, "ok")
+	bad := filepath.Join(dir, "bad")
+	fixture.Files(t, dir, map[string]string{"ok": "x\n", "bad": ""})
+
+	tests := []struct {
+		paths  []string
+		failed int
+		status int
+	}{
+		{[]string{ok, ok}, 0, 0},
+		{[]string{ok, bad, ok, bad}, 2, execFailed},
+	}
+
+	for _, test := range tests {
+		// test -s fails for the empty file.
+		newRun := func() *executor {
+			e, err := newExecutor("test -s {}", false, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range test.paths {
+				e.add("dns", execEntry(path, 0, 0))
+			}
+			return e
+		}
+		if failed := newRun().run(); failed != test.failed {
+			t.Errorf("%q: %d commands failed, want %d", test.paths, failed, test.failed)
+		}
+		if status := newRun().finish(); status != test.status {
+			t.Errorf("%q: exit status %d, want %d", test.paths, status, test.status)
+		}
+	}
+}
+
+func TestCheckModes(t *testing.T) {
+	tests := []struct {
+		set func()
+		ok  bool
+	}{
+		{func() {}, true},
+		{func() { command = "extract" }, true},
+		{func() { execCommand = "echo {}"; extractDir = "/tmp/x" }, true},
+		{func() { execBatchCommand = "echo {}" }, true},
+		{func() { showGaps = true; showSummary = true }, false},
+		{func() { command = "cat"; stageDir = "/tmp/x" }, false},
+		{func() { execCommand = "echo {}"; execBatchCommand = "echo {}" }, false},
+		{func() { execCommand = "echo {}"; stageDir = "/tmp/x" }, false},
+		{func() { execCommand = "echo {}"; showSummary = true }, false},
+		{func() { execBatchCommand = "echo {}"; command = "extract" }, false},
+		{func() { execCommand = "echo {}"; listTimes = true }, false},
+		{func() { execBatchCommand = "echo {}"; humanTimes = true }, false},
+		{func() { execCommand = "echo {}"; showOffsets = true }, false},
+		{func() { execCommand = "echo {}"; showStats = true }, false},
+		{func() { listTimes = true; showStats = true }, true},
+	}
+
+	for i, test := range tests {
+		command, showGaps, stageDir, showSummary = "", false, "", false
+		execCommand, execBatchCommand, extractDir = "", "", ""
+		listTimes, humanTimes, showOffsets, showStats = false, false, false, false
+		test.set()
+		if err := checkModes(); (err == nil) != test.ok {
+			t.Errorf("test %d: got error %v", i, err)
+		}
+	}
+	command, execCommand, execBatchCommand, extractDir, stageDir = "", "", "", "", ""
+	showGaps, showSummary, listTimes, humanTimes, showOffsets, showStats = false, false, false, false, false, false
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"timefind/index"
+	tf_time "timefind/time"
+)
+
+// A gapReport describes how well the data files of a source cover a time
+// window, from the index alone.
+type gapReport struct {
+	Source   string     `json:"source"`
+	Begin    *time.Time `json:"begin,omitempty"` // nil if there's no window
+	End      *time.Time `json:"end,omitempty"`
+	Files    int        `json:"files"`
+	Covered  float64    `json:"covered"` // Seconds
+	Percent  float64    `json:"percent"`
+	Gaps     []gap      `json:"gaps"`
+	Overlaps []overlap  `json:"overlaps"`
+
+	loc *time.Location // What zone to report times in
+}
+
+// A gap is a time in the window with no data.
+type gap struct {
+	Begin    time.Time `json:"begin"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"` // Seconds
+}
+
+// An overlap is a time covered by two data files.
+type overlap struct {
+	Begin time.Time `json:"begin"`
+	End   time.Time `json:"end"`
+	First string    `json:"first"`
+	Other string    `json:"other"`
+}
+
+// newGapReport works out the coverage of window by entries, and the gaps in
+// it longer than minGap. If clipBegin (clipEnd) is set, the window begins
+// (ends) with the data instead, for when the query didn't give a begin (end)
+// time; with no data to clip it to, there's no window, and so no gaps. Times
+// are reported in loc.
+func newGapReport(source string, entries []index.Entry, window tf_time.Times,
+	clipBegin bool, clipEnd bool, minGap time.Duration, loc *time.Location) gapReport {
+
+	// What each file covers: its coverage intervals if it has them, or
+	// else its whole period.
+	var covered []tf_time.Times
+	var data tf_time.Times
+	for _, entry := range entries {
+		intervals := entry.Coverage
+		if len(intervals) == 0 {
+			intervals = []tf_time.Times{entry.Period}
+		}
+		covered = append(covered, intervals...)
+		data.Union(entry.Period)
+	}
+	if clipBegin && len(entries) > 0 {
+		window.Earliest = data.Earliest
+	}
+	if clipEnd && len(entries) > 0 {
+		window.Latest = data.Latest
+	}
+
+	r := gapReport{
+		Source:   source,
+		Files:    len(entries),
+		Gaps:     []gap{},
+		Overlaps: findOverlaps(entries, loc),
+		loc:      loc,
+	}
+	if (clipBegin || clipEnd) && len(entries) == 0 {
+		return r
+	}
+	begin, end := window.Earliest.In(loc), window.Latest.In(loc)
+	r.Begin, r.End = &begin, &end
+
+	// Merge the covered intervals within the window, and note the gaps
+	// between them.
+	sort.Slice(covered, func(i, j int) bool {
+		return covered[i].Earliest.Before(covered[j].Earliest)
+	})
+	total := time.Duration(0)
+	next := window.Earliest // The earliest time not yet covered
+	for _, c := range covered {
+		if c.Earliest.Before(window.Earliest) {
+			c.Earliest = window.Earliest
+		}
+		if c.Latest.After(window.Latest) {
+			c.Latest = window.Latest
+		}
+		if !c.Latest.After(next) {
+			continue
+		}
+		if c.Earliest.After(next) {
+			r.addGap(next, c.Earliest, minGap)
+		} else {
+			c.Earliest = next
+		}
+		total += c.Latest.Sub(c.Earliest)
+		next = c.Latest
+	}
+	if window.Latest.After(next) {
+		r.addGap(next, window.Latest, minGap)
+	}
+
+	r.Covered = total.Seconds()
+	if length := window.Latest.Sub(window.Earliest); length > 0 {
+		r.Percent = 100 * total.Seconds() / length.Seconds()
+	}
+	return r
+}
+
+func (r *gapReport) addGap(begin time.Time, end time.Time, minGap time.Duration) {
+	if end.Sub(begin) > minGap {
+		r.Gaps = append(r.Gaps, gap{begin.In(r.loc), end.In(r.loc), end.Sub(begin).Seconds()})
+	}
+}
+
+// findOverlaps returns where the periods of entries overlap, in loc. Each file
+// is compared with the file before it that ends the latest.
+func findOverlaps(entries []index.Entry, loc *time.Location) []overlap {
+	sorted := append([]index.Entry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Period.Earliest.Before(sorted[j].Period.Earliest)
+	})
+
+	overlaps := []overlap{}
+	for i := 1; i < len(sorted); i++ {
+		prev, cur := sorted[i-1], sorted[i]
+		// Files that only touch, like one ending at 01:00 and the
+		// next starting then, don't overlap.
+		if cur.Period.Earliest.Before(prev.Period.Latest) {
+			end := cur.Period.Latest
+			if prev.Period.Latest.Before(end) {
+				end = prev.Period.Latest
+			}
+			overlaps = append(overlaps,
+				overlap{cur.Period.Earliest.In(loc), end.In(loc), prev.Path, cur.Path})
+		}
+		if prev.Period.Latest.After(cur.Period.Latest) {
+			// Keep comparing with the file that ends the latest.
+			sorted[i] = prev
+		}
+	}
+	return overlaps
+}
+
+func (r gapReport) String() string {
+	if r.Begin == nil {
+		return fmt.Sprintf("%s: %d files, and no window to find gaps in", r.Source, r.Files)
+	}
+	window := r.End.Sub(*r.Begin)
+	covered := time.Duration(r.Covered * float64(time.Second))
+	lines := []string{fmt.Sprintf("%s: %s to %s, %d files, %.2f%% covered (%s of %s), %d gaps, %d overlaps",
+		r.Source, formatTime(*r.Begin), formatTime(*r.End), r.Files, r.Percent,
+		covered, window, len(r.Gaps), len(r.Overlaps))}
+	for _, g := range r.Gaps {
+		lines = append(lines, fmt.Sprintf("  gap %s %s %s",
+			formatTime(g.Begin), formatTime(g.End), g.End.Sub(g.Begin)))
+	}
+	for _, o := range r.Overlaps {
+		lines = append(lines, fmt.Sprintf("  overlap %s %s %s %s",
+			formatTime(o.Begin), formatTime(o.End), o.First, o.Other))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"timefind/index"
+	tf_time "timefind/time"
+)
+
+func gapTimes(earliest, latest int64) tf_time.Times {
+	return tf_time.Times{Earliest: time.Unix(earliest, 0).UTC(), Latest: time.Unix(latest, 0).UTC()}
+}
+
+func gapEntry(path string, earliest, latest int64, coverage ...tf_time.Times) index.Entry {
+	return index.Entry{Path: path, Period: gapTimes(earliest, latest), Coverage: coverage}
+}
+
+func TestGapReport(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []index.Entry
+		window    tf_time.Times
+		clipBegin bool
+		clipEnd   bool
+		minGap    time.Duration
+		gaps      []tf_time.Times
+		covered   float64
+		percent   float64
+	}{
+		{
+			name:    "no files",
+			window:  gapTimes(0, 100),
+			gaps:    []tf_time.Times{gapTimes(0, 100)},
+			covered: 0,
+			percent: 0,
+		},
+		{
+			name:    "gaps at the ends and between",
+			entries: []index.Entry{gapEntry("b", 50, 70), gapEntry("a", 10, 30)},
+			window:  gapTimes(0, 100),
+			gaps:    []tf_time.Times{gapTimes(0, 10), gapTimes(30, 50), gapTimes(70, 100)},
+			covered: 40,
+			percent: 40,
+		},
+		{
+			name: "overlapping and contained files are merged",
+			entries: []index.Entry{
+				gapEntry("a", 0, 60), gapEntry("b", 10, 20), gapEntry("c", 50, 80),
+				gapEntry("d", 90, 100),
+			},
+			window:  gapTimes(0, 100),
+			gaps:    []tf_time.Times{gapTimes(80, 90)},
+			covered: 90,
+			percent: 90,
+		},
+		{
+			name:    "files are clipped to the window",
+			entries: []index.Entry{gapEntry("a", -50, 20), gapEntry("b", 80, 150)},
+			window:  gapTimes(0, 100),
+			gaps:    []tf_time.Times{gapTimes(20, 80)},
+			covered: 40,
+			percent: 40,
+		},
+		{
+			name: "coverage intervals are used instead of the period",
+			entries: []index.Entry{
+				gapEntry("a", 0, 100, gapTimes(0, 30), gapTimes(60, 100)),
+			},
+			window:  gapTimes(0, 100),
+			gaps:    []tf_time.Times{gapTimes(30, 60)},
+			covered: 70,
+			percent: 70,
+		},
+		{
+			name:    "gaps no longer than minGap are left out",
+			entries: []index.Entry{gapEntry("a", 5, 40), gapEntry("b", 50, 100)},
+			window:  gapTimes(0, 100),
+			minGap:  5 * time.Second,
+			gaps:    []tf_time.Times{gapTimes(40, 50)},
+			covered: 85,
+			percent: 85,
+		},
+		{
+			name:      "the window is clipped to the data",
+			entries:   []index.Entry{gapEntry("a", 20, 40), gapEntry("b", 60, 70)},
+			window:    gapTimes(0, 100),
+			clipBegin: true,
+			clipEnd:   true,
+			gaps:      []tf_time.Times{gapTimes(40, 60)},
+			covered:   30,
+			percent:   60,
+		},
+	}
+
+	for _, test := range tests {
+		r := newGapReport("dns", test.entries, test.window,
+			test.clipBegin, test.clipEnd, test.minGap, time.UTC)
+
+		gaps := []tf_time.Times{}
+		for _, g := range r.Gaps {
+			gaps = append(gaps, tf_time.Times{Earliest: g.Begin, Latest: g.End})
+			if g.Duration != g.End.Sub(g.Begin).Seconds() {
+				t.Errorf("%s: gap %v has duration %v", test.name, g, g.Duration)
+			}
+		}
+		if test.gaps == nil {
+			test.gaps = []tf_time.Times{}
+		}
+		if !reflect.DeepEqual(gaps, test.gaps) {
+			t.Errorf("%s: got gaps %v, want %v", test.name, gaps, test.gaps)
+		}
+		if r.Covered != test.covered || r.Percent != test.percent {
+			t.Errorf("%s: got %v seconds (%v%%) covered, want %v (%v%%)",
+				test.name, r.Covered, r.Percent, test.covered, test.percent)
+		}
+		if r.Files != len(test.entries) {
+			t.Errorf("%s: got %d files, want %d", test.name, r.Files, len(test.entries))
+		}
+	}
+}
+
+func TestGapReportNoWindow(t *testing.T) {
+	open := tf_time.Times{Earliest: tf_time.MinTime, Latest: tf_time.MaxTime}
+	for _, clip := range [][2]bool{{true, true}, {true, false}, {false, true}} {
+		r := newGapReport("dns", nil, open, clip[0], clip[1], 0, time.UTC)
+		if r.Begin != nil || r.End != nil || len(r.Gaps) != 0 || r.Covered != 0 {
+			t.Errorf("clip %v: got a window for no files: %s", clip, r)
+		}
+		data, err := json.Marshal([]gapReport{r})
+		if err != nil {
+			t.Fatalf("clip %v: %s", clip, err)
+		}
+		want := `[{"source":"dns","files":0,"covered":0,"percent":0,"gaps":[],"overlaps":[]}]`
+		if string(data) != want {
+			t.Errorf("clip %v: got %s, want %s", clip, data, want)
+		}
+		if want := "dns: 0 files, and no window to find gaps in"; r.String() != want {
+			t.Errorf("clip %v: got %q, want %q", clip, r.String(), want)
+		}
+	}
+
+	// With both ends given, the whole window is a gap.
+	r := newGapReport("dns", nil, gapTimes(0, 100), false, false, 0, time.UTC)
+	if r.Begin == nil || len(r.Gaps) != 1 {
+		t.Errorf("got %s, want one gap", r)
+	}
+}
+
+func TestFindOverlaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []index.Entry
+		want    []overlap
+	}{
+		{
+			name:    "no overlaps",
+			entries: []index.Entry{gapEntry("a", 0, 10), gapEntry("b", 20, 30)},
+			want:    []overlap{},
+		},
+		{
+			name:    "partial overlap",
+			entries: []index.Entry{gapEntry("b", 20, 40), gapEntry("a", 0, 30)},
+			want:    []overlap{{time.Unix(20, 0).UTC(), time.Unix(30, 0).UTC(), "a", "b"}},
+		},
+		{
+			name:    "touching files don't overlap",
+			entries: []index.Entry{gapEntry("a", 0, 10), gapEntry("b", 10, 20), gapEntry("c", 20, 30)},
+			want:    []overlap{},
+		},
+		{
+			name: "files within a long file are compared with it",
+			entries: []index.Entry{
+				gapEntry("a", 0, 100), gapEntry("b", 10, 20), gapEntry("c", 30, 40),
+			},
+			want: []overlap{
+				{time.Unix(10, 0).UTC(), time.Unix(20, 0).UTC(), "a", "b"},
+				{time.Unix(30, 0).UTC(), time.Unix(40, 0).UTC(), "a", "c"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		got := findOverlaps(test.entries, time.UTC)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
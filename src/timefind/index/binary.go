@@ -0,0 +1,363 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	tf_time "timefind/time"
+)
+
+/*
+  Binary index format (NAME.tfi). Everything is little-endian.
+
+  header (32 bytes):
+     0  magic          "TFINDEX\x00"
+     8  version        uint32 (binaryVersion)
+    12  record size    uint32 (bytes per record, for forward compatibility)
+    16  count          uint32 (number of entries)
+    20  restart count  uint32 (number of path restart points)
+    24  paths offset   uint64 (start of the path section)
+
+  records (count * record size bytes), sorted by earliest:
+     0  earliest       int64 (Unix nanoseconds)
+     8  latest         int64
+    16  modified       int64
+    24  parsed         int64
+    32  skipped        int64
+    40  failed         int64
+    48  path           uint32 (index into the sorted path list)
+    52  coverage       uint32 (1 + offset into the coverage section, or 0 if
+                       there's no coverage)
+    56  size           int64
+    64  inode          uint64
+    72  fingerprint    16 bytes (all zero if there isn't one)
+    88  uncompressed   int64
+
+  restarts (restart count * 8 bytes):
+     byte offset (relative to the path section) of every binaryRestart'th
+     path, so that any path can be found without decoding all of them
+
+  paths, sorted, each one prefix-compressed against the previous one:
+     uvarint  length of the prefix shared with the previous path
+              (always 0 at a restart point)
+     uvarint  length of the rest of the path
+     bytes    the rest of the path
+
+  coverage, for each record that has any:
+     uvarint  number of intervals
+     then for each interval, in nanoseconds:
+       varint  start, less the end of the previous interval (for the first
+               one, the start itself)
+       varint  end, less start
+
+  The file is memory-mapped and decoded from the mapping rather than read
+  into memory first; since the records are fixed width and sorted, they
+  could also be searched in place. Records longer than binaryRecordSize (from
+  a later version that added fields) are read, ignoring the extra fields. The
+  zero time.Time (which doesn't fit in int64 nanoseconds) is stored as
+  math.MinInt64, and other times that don't fit, before 1678 or after 2262,
+  are clamped to the nearest one that does.
+*/
+
+const (
+	binaryMagic      = "TFINDEX\x00"
+	binaryVersion    = 1
+	binaryHeaderSize = 32
+	binaryRecordSize = 96
+	binaryRestart    = 16
+)
+
+var errBadBinary = errors.New("not a valid binary index")
+
+var (
+	minBinaryTime = time.Unix(0, math.MinInt64+1)
+	maxBinaryTime = time.Unix(0, math.MaxInt64)
+)
+
+func encodeTime(t time.Time) int64 {
+	switch {
+	case t.IsZero():
+		return math.MinInt64
+	case t.Before(minBinaryTime):
+		return math.MinInt64 + 1
+	case t.After(maxBinaryTime):
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
+
+func decodeTime(ns int64) time.Time {
+	if ns == math.MinInt64 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns).UTC()
+}
+
+func writeBinary(w io.Writer, entries []Entry) error {
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = entry.Path
+	}
+	sort.Strings(paths)
+
+	pathIndex := make(map[string]uint32, len(paths))
+	var pathBuf bytes.Buffer
+	restarts := []uint64{}
+	varint := make([]byte, binary.MaxVarintLen64)
+	prev := ""
+
+	for i, path := range paths {
+		pathIndex[path] = uint32(i)
+
+		shared := 0
+		if i%binaryRestart == 0 {
+			restarts = append(restarts, uint64(pathBuf.Len()))
+		} else {
+			for shared < len(prev) && shared < len(path) && prev[shared] == path[shared] {
+				shared++
+			}
+		}
+
+		n := binary.PutUvarint(varint, uint64(shared))
+		pathBuf.Write(varint[:n])
+		n = binary.PutUvarint(varint, uint64(len(path)-shared))
+		pathBuf.Write(varint[:n])
+		pathBuf.WriteString(path[shared:])
+
+		prev = path
+	}
+
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := encodeTime(sorted[i].Period.Earliest), encodeTime(sorted[j].Period.Earliest)
+		if a == b {
+			return sorted[i].Path < sorted[j].Path
+		}
+		return a < b
+	})
+
+	bw := bufio.NewWriter(w)
+
+	header := make([]byte, binaryHeaderSize)
+	copy(header, binaryMagic)
+	binary.LittleEndian.PutUint32(header[8:], binaryVersion)
+	binary.LittleEndian.PutUint32(header[12:], binaryRecordSize)
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(sorted)))
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(restarts)))
+	binary.LittleEndian.PutUint64(header[24:],
+		uint64(binaryHeaderSize+len(sorted)*binaryRecordSize+len(restarts)*8))
+	bw.Write(header)
+
+	var coverageBuf bytes.Buffer
+	putVarint := func(x int64) {
+		n := binary.PutVarint(varint, x)
+		coverageBuf.Write(varint[:n])
+	}
+
+	rec := make([]byte, binaryRecordSize)
+	for _, entry := range sorted {
+		coverage := uint32(0)
+		if len(entry.Coverage) > 0 {
+			coverage = uint32(coverageBuf.Len() + 1)
+			n := binary.PutUvarint(varint, uint64(len(entry.Coverage)))
+			coverageBuf.Write(varint[:n])
+
+			prev := int64(0)
+			for _, interval := range entry.Coverage {
+				start, end := encodeTime(interval.Earliest), encodeTime(interval.Latest)
+				putVarint(start - prev)
+				putVarint(end - start)
+				prev = end
+			}
+		}
+
+		binary.LittleEndian.PutUint64(rec[0:], uint64(encodeTime(entry.Period.Earliest)))
+		binary.LittleEndian.PutUint64(rec[8:], uint64(encodeTime(entry.Period.Latest)))
+		binary.LittleEndian.PutUint64(rec[16:], uint64(encodeTime(entry.Modified)))
+		binary.LittleEndian.PutUint64(rec[24:], uint64(entry.Stats.Parsed))
+		binary.LittleEndian.PutUint64(rec[32:], uint64(entry.Stats.Skipped))
+		binary.LittleEndian.PutUint64(rec[40:], uint64(entry.Stats.Failed))
+		binary.LittleEndian.PutUint32(rec[48:], pathIndex[entry.Path])
+		binary.LittleEndian.PutUint32(rec[52:], coverage)
+		binary.LittleEndian.PutUint64(rec[56:], uint64(entry.Size))
+		binary.LittleEndian.PutUint64(rec[64:], entry.Inode)
+		copy(rec[72:88], make([]byte, 16))
+		if fp, err := hex.DecodeString(entry.Fingerprint); err == nil {
+			copy(rec[72:88], fp)
+		}
+		binary.LittleEndian.PutUint64(rec[88:], uint64(entry.Uncompressed))
+		bw.Write(rec)
+	}
+
+	for _, restart := range restarts {
+		binary.LittleEndian.PutUint64(varint, restart)
+		bw.Write(varint[:8])
+	}
+
+	bw.Write(pathBuf.Bytes())
+	bw.Write(coverageBuf.Bytes())
+
+	return bw.Flush()
+}
+
+func readBinary(filename string) ([]Entry, indexHeader, error) {
+	hdr := indexHeader{Time: "unix-nano"}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, hdr, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, hdr, err
+	}
+	if info.Size() < binaryHeaderSize {
+		return nil, hdr, fmt.Errorf("%s: %s", filename, errBadBinary)
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, hdr, err
+	}
+	defer syscall.Munmap(data)
+
+	// Nothing decoded may refer to data once it's unmapped; the paths are
+	// copied out of it.
+	entries, err := decodeBinary(data)
+	if err != nil {
+		return nil, hdr, fmt.Errorf("%s: %s", filename, err)
+	}
+	hdr.Version = int(binary.LittleEndian.Uint32(data[8:]))
+	return entries, hdr, nil
+}
+
+func decodeBinary(data []byte) ([]Entry, error) {
+	if len(data) < binaryHeaderSize || string(data[:8]) != binaryMagic {
+		return nil, errBadBinary
+	}
+	if version := binary.LittleEndian.Uint32(data[8:]); version > binaryVersion {
+		return nil, fmt.Errorf("unsupported binary index version %d", version)
+	}
+
+	recordSize := uint64(binary.LittleEndian.Uint32(data[12:]))
+	count := uint64(binary.LittleEndian.Uint32(data[16:]))
+	nrestarts := uint64(binary.LittleEndian.Uint32(data[20:]))
+	pathsOff := binary.LittleEndian.Uint64(data[24:])
+
+	if recordSize < binaryRecordSize ||
+		pathsOff != binaryHeaderSize+count*recordSize+nrestarts*8 ||
+		pathsOff > uint64(len(data)) {
+		return nil, errBadBinary
+	}
+
+	// Decode the paths in order; we want all of them anyway.
+	paths := make([]string, 0, count)
+	pathData := data[pathsOff:]
+	prev := ""
+	for uint64(len(paths)) < count {
+		shared, n := binary.Uvarint(pathData)
+		if n <= 0 || shared > uint64(len(prev)) {
+			return nil, errBadBinary
+		}
+		pathData = pathData[n:]
+
+		length, n := binary.Uvarint(pathData)
+		if n <= 0 || length > uint64(len(pathData)-n) {
+			return nil, errBadBinary
+		}
+		pathData = pathData[n:]
+
+		path := prev[:shared] + string(pathData[:length])
+		pathData = pathData[length:]
+
+		paths = append(paths, path)
+		prev = path
+	}
+
+	// The coverage section follows the paths.
+	coverageData := pathData
+
+	entries := make([]Entry, 0, count)
+	for i := uint64(0); i < count; i++ {
+		rec := data[binaryHeaderSize+i*recordSize:]
+
+		p := uint64(binary.LittleEndian.Uint32(rec[48:]))
+		if p >= count {
+			return nil, errBadBinary
+		}
+
+		entry := Entry{Path: paths[p]}
+		entry.Period.Earliest = decodeTime(int64(binary.LittleEndian.Uint64(rec[0:])))
+		entry.Period.Latest = decodeTime(int64(binary.LittleEndian.Uint64(rec[8:])))
+		entry.Modified = decodeTime(int64(binary.LittleEndian.Uint64(rec[16:])))
+		entry.Stats.Parsed = int64(binary.LittleEndian.Uint64(rec[24:]))
+		entry.Stats.Skipped = int64(binary.LittleEndian.Uint64(rec[32:]))
+		entry.Stats.Failed = int64(binary.LittleEndian.Uint64(rec[40:]))
+
+		entry.Size = int64(binary.LittleEndian.Uint64(rec[56:]))
+		entry.Inode = binary.LittleEndian.Uint64(rec[64:])
+		if fp := rec[72:88]; !bytes.Equal(fp, make([]byte, 16)) {
+			entry.Fingerprint = hex.EncodeToString(fp)
+		}
+		entry.Uncompressed = int64(binary.LittleEndian.Uint64(rec[88:]))
+
+		if off := uint64(binary.LittleEndian.Uint32(rec[52:])); off > 0 {
+			if off > uint64(len(coverageData)) {
+				return nil, errBadBinary
+			}
+			coverage, err := decodeCoverage(coverageData[off-1:])
+			if err != nil {
+				return nil, err
+			}
+			entry.Coverage = coverage
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func decodeCoverage(data []byte) ([]tf_time.Times, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, errBadBinary
+	}
+	data = data[n:]
+
+	coverage := make([]tf_time.Times, 0, count)
+	prev := int64(0)
+	for i := uint64(0); i < count; i++ {
+		start, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, errBadBinary
+		}
+		data = data[n:]
+		length, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, errBadBinary
+		}
+		data = data[n:]
+
+		start += prev
+		prev = start + length
+		coverage = append(coverage, tf_time.Times{
+			Earliest: decodeTime(start),
+			Latest:   decodeTime(prev),
+		})
+	}
+	return coverage, nil
+}
@@ -0,0 +1,254 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"timefind/internal/fixture"
+	"timefind/processor"
+	tf_time "timefind/time"
+)
+
+func testEntries() []Entry {
+	at := func(sec int64, nsec int64) time.Time { return time.Unix(sec, nsec).UTC() }
+	return []Entry{
+		{
+			Path:         "/data/pcap/2015/a.pcap.gz",
+			Period:       tf_time.Times{Earliest: at(1436000000, 5), Latest: at(1436003600, 999999999)},
+			Modified:     at(1436003700, 0),
+			Stats:        processor.Stats{Parsed: 100, Skipped: 2, Failed: 1},
+			Size:         12345,
+			Inode:        987654321,
+			Fingerprint:  "00112233445566778899aabbccddeeff",
+			Uncompressed: 54321,
+			Coverage: []tf_time.Times{
+				{Earliest: at(1436000000, 0), Latest: at(1436000599, 999999999)},
+				{Earliest: at(1436003000, 0), Latest: at(1436003600, 999999999)},
+			},
+		},
+		{
+			Path:     "/data/pcap/2015/b.pcap.gz",
+			Period:   tf_time.Times{Earliest: at(1435000000, 0), Latest: at(1435000001, 0)},
+			Modified: at(1435000002, 0),
+		},
+		{
+			// A directory, with the totals of its files.
+			Path:   "2015",
+			Period: tf_time.Times{Earliest: at(1435000000, 0), Latest: at(1436003600, 999999999)},
+			Stats:  processor.Stats{Parsed: 1000},
+			Size:   99999,
+		},
+		{
+			// Never indexed: every time is zero.
+			Path: "/data/pcap/2015/c.pcap.gz",
+		},
+	}
+}
+
+// byPath returns entries keyed by their path, for comparing regardless of
+// order.
+func byPath(entries []Entry) map[string]Entry {
+	m := map[string]Entry{}
+	for _, entry := range entries {
+		m[entry.Path] = entry
+	}
+	return m
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	want := testEntries()
+	filename := filepath.Join(t.TempDir(), "test.tfi")
+	var buf bytes.Buffer
+	if err := writeBinary(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, hdr, err := readBinary(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Version != binaryVersion {
+		t.Errorf("version %d, expected %d", hdr.Version, binaryVersion)
+	}
+	if !reflect.DeepEqual(byPath(got), byPath(want)) {
+		t.Errorf("got %+v\nexpected %+v", got, want)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Period.Earliest.Before(got[i-1].Period.Earliest) {
+			t.Errorf("entries not sorted by earliest: %v", got)
+		}
+	}
+}
+
+func TestBinaryCoverage(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0).UTC() }
+	interval := func(earliest, latest int64) tf_time.Times {
+		return tf_time.Times{Earliest: at(earliest), Latest: at(latest)}
+	}
+
+	tests := []struct {
+		name     string
+		period   tf_time.Times
+		coverage []tf_time.Times
+	}{
+		{"one interval", interval(1436000000, 1436003600),
+			[]tf_time.Times{interval(1436000000, 1436003600)}},
+		{"several", interval(1436000000, 1436003600),
+			[]tf_time.Times{interval(1436000000, 1436000001), interval(1436003000, 1436003600)}},
+		// Earliest is unset, so it's stored as math.MinInt64; coverage
+		// mustn't be relative to it.
+		{"zero period", tf_time.Times{},
+			[]tf_time.Times{interval(1436000000, 1436003600)}},
+		{"zero earliest", tf_time.Times{Latest: at(1436003600)},
+			[]tf_time.Times{interval(1436000000, 1436003600)}},
+		{"before 1970", interval(-86400, 0),
+			[]tf_time.Times{interval(-86400, -3600), interval(-60, 0)}},
+		{"far apart", interval(0, 7000000000),
+			[]tf_time.Times{interval(0, 1), interval(6999999999, 7000000000)}},
+	}
+
+	for _, test := range tests {
+		want := []Entry{{Path: "/data/a", Period: test.period, Coverage: test.coverage}}
+		var buf bytes.Buffer
+		if err := writeBinary(&buf, want); err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(t.TempDir(), "test.tfi")
+		if err := os.WriteFile(filename, buf.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := readBinary(filename)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, got, want)
+		}
+	}
+}
+
+func TestBinaryOutOfRange(t *testing.T) {
+	year := func(y int) time.Time { return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC) }
+	first, last := time.Unix(0, math.MinInt64+1).UTC(), time.Unix(0, math.MaxInt64).UTC()
+
+	// Times that don't fit in int64 nanoseconds are clamped, rather than
+	// wrapping around to somewhere else. (Year 1 would be the zero time.)
+	entry := Entry{
+		Path:     "/data/a",
+		Period:   tf_time.Times{Earliest: year(2), Latest: year(9999)},
+		Coverage: []tf_time.Times{{Earliest: year(2), Latest: year(3)}, {Earliest: year(9998), Latest: year(9999)}},
+		Modified: year(2015),
+	}
+	want := []Entry{{
+		Path:     "/data/a",
+		Period:   tf_time.Times{Earliest: first, Latest: last},
+		Coverage: []tf_time.Times{{Earliest: first, Latest: first}, {Earliest: last, Latest: last}},
+		Modified: year(2015),
+	}}
+
+	var buf bytes.Buffer
+	if err := writeBinary(&buf, []Entry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "test.tfi")
+	if err := os.WriteFile(filename, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := readBinary(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+}
+
+func TestBinaryBad(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeBinary(&buf, testEntries()); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	newer := append([]byte{}, good...)
+	binary.LittleEndian.PutUint32(newer[8:], binaryVersion+1)
+	short := append([]byte{}, good...)
+	binary.LittleEndian.PutUint32(short[12:], binaryRecordSize-8)
+
+	tests := map[string][]byte{
+		"empty":           {},
+		"header only":     good[:binaryHeaderSize-1],
+		"bad magic":       append([]byte("TFINDEX!"), good[8:]...),
+		"newer version":   newer,
+		"short records":   short,
+		"truncated":       good[:binaryHeaderSize+binaryRecordSize],
+		"truncated paths": good[:len(good)-200],
+	}
+	dir := t.TempDir()
+	for name, data := range tests {
+		filename := filepath.Join(dir, "test.tfi")
+		if err := os.WriteFile(filename, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if entries, _, err := readBinary(filename); err == nil {
+			t.Errorf("%s: got %d entries, expected an error", name, len(entries))
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	cfg := fixture.Source(t, "test", "pcap", nil)
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range testEntries() {
+		if !entry.isDir() {
+			idx.entries[entry.Path] = entry
+		}
+	}
+	if err := idx.WriteOut(); err != nil {
+		t.Fatal(err)
+	}
+
+	// CSV to binary and back, leaving only the configured format behind.
+	for _, format := range []string{FormatBinary, FormatCSV} {
+		cfg.IndexFormat = format
+		idx, err := NewIndex(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx.Outdated() != 1 {
+			t.Errorf("%s: %d outdated, expected 1", format, idx.Outdated())
+		}
+		if err := idx.WriteOut(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(indexFilename(cfg, "", otherFormat(format))); !os.IsNotExist(err) {
+			t.Errorf("%s: the %s index is still there", format, otherFormat(format))
+		}
+
+		idx, err = NewIndex(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx.Outdated() != 0 {
+			t.Errorf("%s: %d outdated after converting", format, idx.Outdated())
+		}
+		want := byPath(testEntries())
+		delete(want, "2015")
+		if !reflect.DeepEqual(idx.entries, want) {
+			t.Errorf("%s: got %+v\nexpected %+v", format, idx.entries, want)
+		}
+	}
+}
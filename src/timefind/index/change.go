@@ -0,0 +1,92 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"syscall"
+)
+
+// How much of each end of a data file goes into its fingerprint.
+const fingerprintBlock = 64 * 1024
+
+// identify returns what we know about the data file at path, other than
+// what's in it: its modification time, size, inode and (if the source is
+// configured with "fingerprint") a fingerprint of its contents.
+func (idx *Index) identify(path string, info os.FileInfo) (Entry, error) {
+	id := Entry{
+		Modified: info.ModTime(),
+		Size:     info.Size(),
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		id.Inode = uint64(st.Ino)
+	}
+
+	if idx.Config.Fingerprint {
+		fp, err := fingerprint(path, info.Size())
+		if err != nil {
+			return id, err
+		}
+		id.Fingerprint = fp
+	}
+
+	return id, nil
+}
+
+// fingerprint hashes the size and the first and last blocks of a file. It
+// notices most rewrites without reading the whole file.
+func fingerprint(path string, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, size)
+
+	if _, err := io.CopyN(h, f, fingerprintBlock); err != nil && err != io.EOF {
+		return "", err
+	}
+	if size > fingerprintBlock {
+		tail := int64(fingerprintBlock)
+		if size < 2*fingerprintBlock {
+			tail = size - fingerprintBlock
+		}
+		if _, err := f.Seek(-tail, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(h, f, tail); err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// isCurrent reports whether entry was indexed from the data file that id
+// describes. The size, inode and fingerprint are only compared if entry has
+// them; indexes written by older versions don't.
+func (entry *Entry) isCurrent(id Entry) bool {
+	if !id.Modified.Equal(entry.Modified) {
+		return false
+	}
+	if entry.Inode != 0 && (entry.Inode != id.Inode || entry.Size != id.Size) {
+		return false
+	}
+	if entry.Fingerprint != "" && id.Fingerprint != "" &&
+		entry.Fingerprint != id.Fingerprint {
+		return false
+	}
+	return true
+}
+
+// setID copies the identity of the data file from id into entry.
+func (entry *Entry) setID(id Entry) {
+	entry.Modified = id.Modified
+	entry.Size = id.Size
+	entry.Inode = id.Inode
+	entry.Fingerprint = id.Fingerprint
+}
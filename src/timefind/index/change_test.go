@@ -0,0 +1,144 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"timefind/internal/fixture"
+)
+
+func TestIsCurrent(t *testing.T) {
+	now := time.Unix(1436000000, 0)
+	indexed := Entry{Modified: now, Size: 100, Inode: 7, Fingerprint: "aa"}
+
+	tests := []struct {
+		name    string
+		entry   Entry
+		id      Entry
+		current bool
+	}{
+		{"unchanged", indexed, indexed, true},
+		{"modified", indexed, Entry{Modified: now.Add(time.Second), Size: 100, Inode: 7, Fingerprint: "aa"}, false},
+		{"resized", indexed, Entry{Modified: now, Size: 101, Inode: 7, Fingerprint: "aa"}, false},
+		{"replaced", indexed, Entry{Modified: now, Size: 100, Inode: 8, Fingerprint: "aa"}, false},
+		{"rewritten", indexed, Entry{Modified: now, Size: 100, Inode: 7, Fingerprint: "bb"}, false},
+		{"not fingerprinted now", indexed, Entry{Modified: now, Size: 100, Inode: 7}, true},
+
+		// Entries from older indexes only have the modification time.
+		{"old index", Entry{Modified: now}, Entry{Modified: now, Size: 5, Inode: 9, Fingerprint: "cc"}, true},
+		{"old index modified", Entry{Modified: now}, Entry{Modified: now.Add(1)}, false},
+		{"not fingerprinted then", Entry{Modified: now, Size: 100, Inode: 7},
+			Entry{Modified: now, Size: 100, Inode: 7, Fingerprint: "aa"}, true},
+	}
+
+	for _, test := range tests {
+		if current := test.entry.isCurrent(test.id); current != test.current {
+			t.Errorf("%s: got %v, expected %v", test.name, current, test.current)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	const n = 3 * fingerprintBlock
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	changed := func(at int) []byte {
+		d := append([]byte{}, data...)
+		d[at] ^= 1
+		return d
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		same bool // Whether the fingerprint matches the original's
+	}{
+		{"same", append([]byte{}, data...), true},
+		{"first byte", changed(0), false},
+		{"end of the first block", changed(fingerprintBlock - 1), false},
+		{"last byte", changed(n - 1), false},
+		{"start of the last block", changed(n - fingerprintBlock), false},
+		// The middle isn't read.
+		{"middle", changed(n / 2), true},
+		{"shorter", data[:n-1], false},
+		{"longer", append(append([]byte{}, data...), 0), false},
+	}
+
+	dir := t.TempDir()
+	fp := func(name string, data []byte) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		fp, err := fingerprint(filename, int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+	orig := fp("orig", data)
+	for _, test := range tests {
+		if same := fp(test.name, test.data) == orig; same != test.same {
+			t.Errorf("%s: fingerprints match is %v, expected %v", test.name, same, test.same)
+		}
+	}
+
+	// Files smaller than a block, or than two, are hashed whole.
+	for _, size := range []int{0, 1, fingerprintBlock, fingerprintBlock + 1, 2*fingerprintBlock - 1} {
+		small := data[:size]
+		if size > 0 && fp("small", small) == fp("small", changed(size - 1)[:size]) {
+			t.Errorf("size %d: last byte not fingerprinted", size)
+		}
+	}
+}
+
+func TestUpdateRewritten(t *testing.T) {
+	tests := []struct {
+		name        string
+		fingerprint bool
+		reindexed   bool
+	}{
+		{"without fingerprints", false, false},
+		{"with fingerprints", true, true},
+	}
+
+	for _, test := range tests {
+		cfg := fixture.Source(t, "test", "fsdb_time_col_1",
+			map[string]string{"a.fsdb": "1436000000\tx\n1436000100\ty\n"})
+		cfg.Fingerprint = test.fingerprint
+		filename := filepath.Join(cfg.Paths[0], "a.fsdb")
+		idx, err := NewIndex(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Update(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Rewrite the file in place, with the same size and
+		// modification time.
+		if err := os.WriteFile(filename, []byte("1436000500\tx\n1436000600\ty\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		entry := idx.entries[filename]
+		if reindexed := entry.Period.Earliest.Equal(time.Unix(1436000500, 0)); reindexed != test.reindexed {
+			t.Errorf("%s: got %v, reindexed is %v, expected %v",
+				test.name, entry.Period, reindexed, test.reindexed)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"timefind/config"
+	"timefind/processor"
+	tf_time "timefind/time"
+)
+
+// Kinds of inconsistency found by Check. Each one is a distinct bit, so that
+// timefind_indexer --check can exit with every kind it found.
+const (
+	CheckStale     = 1 << (iota + 1) // A ".new" file left by an unfinished run
+	CheckMissing                     // A directory entry whose sub index is gone
+	CheckOrphan                      // A sub index that no directory entry refers to
+	CheckPeriod                      // A directory entry that doesn't cover its sub index
+	CheckDuplicate                   // A path listed more than once in an index
+)
+
+// A Problem is an inconsistency in an index tree.
+type Problem struct {
+	Kind     int    // One of the Check constants
+	Filename string // The index file (or directory) with the problem
+	Message  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Filename, p.Message)
+}
+
+// Check reads the whole index tree and returns every inconsistency in it.
+//
+// With repair, Check also fixes what it can in memory: directory entries get
+// the period and record counts of their sub indexes, entries whose sub index
+// is gone are dropped, and of the entries for the same path, only the most
+// recently modified one is kept (see subIndex). WriteOut then saves
+// the repaired tree. Leftover ".new" files are removed right away, so the
+// caller must hold the indexer lock. Orphaned sub indexes are only reported;
+// the next update picks them up again if their data directory still exists.
+func (idx *Index) Check(repair bool) ([]Problem, error) {
+	problems := []Problem{}
+	reached := map[string]bool{}
+
+	if err := idx.check(repair, reached, &problems); err != nil {
+		return problems, err
+	}
+
+	err := filepath.Walk(idx.Config.IndexDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		if isStale(idx.Config, path) {
+			problems = append(problems, Problem{CheckStale, path,
+				"left over from an unfinished timefind_indexer run"})
+			if repair {
+				return os.Remove(path)
+			}
+			return nil
+		}
+
+		if !isIndexFile(idx.Config, info.Name()) {
+			return nil
+		}
+		subDir, err := filepath.Rel(idx.Config.IndexDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if subDir == "." {
+			subDir = ""
+		}
+		if !reached[subDir] {
+			problems = append(problems, Problem{CheckOrphan, path,
+				"no directory entry refers to this index"})
+		}
+		return nil
+	})
+
+	return problems, err
+}
+
+func (idx *Index) check(repair bool, reached map[string]bool, problems *[]Problem) error {
+	reached[idx.subDir] = true
+
+	for _, dup := range idx.duplicates {
+		kept := idx.entries[dup.Path]
+		*problems = append(*problems, Problem{CheckDuplicate, idx.Filename,
+			fmt.Sprintf("%s is listed more than once; dropping the entry modified %s "+
+				"for %s, keeping the one modified %s for %s",
+				dup.Path, timeString(dup.Modified), periodString(dup.Period),
+				timeString(kept.Modified), periodString(kept.Period))})
+	}
+	if repair {
+		idx.duplicates = nil
+	}
+
+	for path, entry := range idx.entries {
+		if !entry.isDir() {
+			continue
+		}
+
+		subDir := filepath.Join(idx.subDir, path)
+		if !indexExists(idx.Config, subDir) {
+			*problems = append(*problems, Problem{CheckMissing, idx.Filename,
+				fmt.Sprintf("directory %s has no index", path)})
+			if repair {
+				delete(idx.entries, path)
+			}
+			continue
+		}
+
+		subidx := idx.loadSubIndex(path)
+		if subidx == nil {
+			return fmt.Errorf("Could not read index from subdirectory: %s", subDir)
+		}
+		if err := subidx.check(repair, reached, problems); err != nil {
+			return err
+		}
+
+		if !covers(entry.Period, subidx.Period) {
+			*problems = append(*problems, Problem{CheckPeriod, idx.Filename,
+				fmt.Sprintf("directory %s covers %s, but its index covers %s",
+					path, periodString(entry.Period), periodString(subidx.Period))})
+		}
+		if repair {
+			entry = idx.entries[path]
+			entry.Period = subidx.Period
+			entry.Stats = subidx.Stats
+			entry.Size = subidx.Size
+			entry.Uncompressed = subidx.Uncompressed
+			idx.entries[path] = entry
+		}
+	}
+
+	if repair {
+		idx.Period = tf_time.Times{}
+		idx.Stats = processor.Stats{}
+		for _, entry := range idx.entries {
+			idx.Period.Union(entry.Period)
+			idx.Stats.Sum(entry.Stats)
+		}
+		idx.sumSizes()
+		idx.byStart = nil
+	}
+
+	return nil
+}
+
+// covers reports whether period includes all of sub. An empty sub index is
+// covered by anything.
+func covers(period tf_time.Times, sub tf_time.Times) bool {
+	if sub.Earliest.IsZero() && sub.Latest.IsZero() {
+		return true
+	}
+	return !sub.Earliest.Before(period.Earliest) && !sub.Latest.After(period.Latest)
+}
+
+func periodString(period tf_time.Times) string {
+	return fmt.Sprintf("%s-%s", timeString(period.Earliest), timeString(period.Latest))
+}
+
+func timeString(t time.Time) string {
+	s, _ := tf_time.MarshalTime(t)
+	return string(s)
+}
+
+// isIndexFile reports whether name is an index file of cfg, in either format.
+func isIndexFile(cfg *config.Configuration, name string) bool {
+	for _, format := range []string{FormatCSV, FormatBinary} {
+		if name == filepath.Base(indexFilename(cfg, "", format)) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexExists reports whether there's an index file for subDir, in either
+// format.
+func indexExists(cfg *config.Configuration, subDir string) bool {
+	for _, format := range []string{FormatCSV, FormatBinary} {
+		if _, err := os.Stat(indexFilename(cfg, subDir, format)); err == nil {
+			return true
+		}
+	}
+	return false
+}
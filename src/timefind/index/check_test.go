@@ -0,0 +1,182 @@
+package index
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"timefind/config"
+	"timefind/internal/fixture"
+)
+
+// testTree indexes a data directory with one file at the top and one in the
+// subdirectory 2015, and returns the configuration.
+func testTree(t *testing.T) *config.Configuration {
+	cfg := fixture.Source(t, "test", "fsdb_time_col_1", map[string]string{
+		"a.fsdb":      "1436000000\tx\n1436000100\ty\n",
+		"2015/b.fsdb": "1435000000\tx\n1435000100\ty\n",
+	})
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteOut(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// rewrite replaces the entries of a CSV index file with edit(entries).
+func rewrite(t *testing.T, filename string, edit func([]Entry) []Entry) {
+	entries, _, err := readCSV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, edit(entries), "fsdb_time_col_1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkKinds(t *testing.T, cfg *config.Configuration, repair bool) (int, []Problem) {
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := idx.Check(repair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := 0
+	for _, problem := range problems {
+		kinds |= problem.Kind
+	}
+	if repair && len(problems) > 0 {
+		if err := idx.WriteOut(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return kinds, problems
+}
+
+func TestCheck(t *testing.T) {
+	top := func(cfg *config.Configuration) string { return indexFilename(cfg, "", FormatCSV) }
+	sub := func(cfg *config.Configuration) string { return indexFilename(cfg, "2015", FormatCSV) }
+
+	tests := []struct {
+		name     string
+		damage   func(t *testing.T, cfg *config.Configuration)
+		kinds    int // What Check finds
+		repaired int // What Check finds after repairing
+	}{
+		{"consistent", func(t *testing.T, cfg *config.Configuration) {}, 0, 0},
+		{"stale", func(t *testing.T, cfg *config.Configuration) {
+			if err := os.WriteFile(sub(cfg)+".new", nil, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckStale, 0},
+		{"stale checkpoints", func(t *testing.T, cfg *config.Configuration) {
+			dir := filepath.Join(cfg.IndexDir, "2015", cfg.Name+".checkpoints")
+			if err := os.MkdirAll(dir, 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "b.fsdb-01234567.new"), nil, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckStale, 0},
+		{"missing", func(t *testing.T, cfg *config.Configuration) {
+			if err := os.Remove(sub(cfg)); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckMissing, 0},
+		{"orphan", func(t *testing.T, cfg *config.Configuration) {
+			orphan := indexFilename(cfg, "2016", FormatCSV)
+			if err := os.MkdirAll(filepath.Dir(orphan), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Link(sub(cfg), orphan); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckOrphan, CheckOrphan},
+		{"period", func(t *testing.T, cfg *config.Configuration) {
+			rewrite(t, top(cfg), func(entries []Entry) []Entry {
+				for i := range entries {
+					if entries[i].isDir() {
+						entries[i].Period.Latest = entries[i].Period.Earliest
+					}
+				}
+				return entries
+			})
+		}, CheckPeriod, 0},
+		{"duplicate", func(t *testing.T, cfg *config.Configuration) {
+			rewrite(t, sub(cfg), func(entries []Entry) []Entry {
+				old := entries[0]
+				old.Modified = old.Modified.Add(-time.Hour)
+				old.Period.Latest = old.Period.Latest.Add(time.Hour)
+				return append(entries, old)
+			})
+		}, CheckDuplicate, 0},
+		{"several", func(t *testing.T, cfg *config.Configuration) {
+			if err := os.WriteFile(top(cfg)+".new", nil, 0666); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(sub(cfg)); err != nil {
+				t.Fatal(err)
+			}
+		}, CheckStale | CheckMissing, 0},
+	}
+
+	for _, test := range tests {
+		cfg := testTree(t)
+		test.damage(t, cfg)
+		if kinds, problems := checkKinds(t, cfg, false); kinds != test.kinds {
+			t.Errorf("%s: found %d (%v), expected %d", test.name, kinds, problems, test.kinds)
+		}
+		// Checking again finds the same problems, so the first check
+		// didn't repair anything.
+		if kinds, _ := checkKinds(t, cfg, true); kinds != test.kinds {
+			t.Errorf("%s: repair found %d, expected %d", test.name, kinds, test.kinds)
+		}
+		if kinds, problems := checkKinds(t, cfg, false); kinds != test.repaired {
+			t.Errorf("%s: found %d (%v) after repairing, expected %d",
+				test.name, kinds, problems, test.repaired)
+		}
+	}
+}
+
+func TestCheckDuplicate(t *testing.T) {
+	cfg := testTree(t)
+	filename := indexFilename(cfg, "2015", FormatCSV)
+	var newest Entry
+	rewrite(t, filename, func(entries []Entry) []Entry {
+		newest = entries[0]
+		older := newest
+		older.Modified = newest.Modified.Add(-time.Hour)
+		older.Period.Latest = newest.Period.Latest.Add(time.Hour)
+		// The older entry comes last, but the newer one is still kept.
+		return append(entries, older)
+	})
+
+	_, problems := checkKinds(t, cfg, true)
+	if len(problems) != 1 || !strings.Contains(problems[0].Message, "dropping the entry modified") {
+		t.Fatalf("got %v, expected one duplicate", problems)
+	}
+
+	entries, _, err := readCSV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].Modified.Equal(newest.Modified) ||
+		!entries[0].Period.Latest.Equal(newest.Period.Latest) {
+		t.Errorf("after repairing got %+v, expected %+v", entries, newest)
+	}
+}
@@ -0,0 +1,181 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"timefind/config"
+	"timefind/processor"
+	tf_time "timefind/time"
+)
+
+/*
+  Checkpoints (see processor.Checkpoint) are kept out of the index itself, in
+  one small CSV file per data file:
+
+    INDEXDIR/SUBDIR/NAME.checkpoints/BASENAME-HASH
+
+  where HASH tells apart data files with the same name under different
+  "paths". The first row describes the data file the checkpoints were taken
+  from, and every other row is a checkpoint:
+
+    #timefind-checkpoints,version=1,modified=1436000000.000000000,size=123456
+    67108880,1435999123.000000000
+
+  Checkpoints whose data file has since changed are ignored.
+*/
+
+const checkpointsMagic = "#timefind-checkpoints"
+
+func checkpointsFilename(cfg *config.Configuration, path string) (string, bool) {
+	for _, base := range cfg.Paths {
+		subDir, err := filepath.Rel(base, filepath.Dir(path))
+		if err != nil || strings.HasPrefix(subDir, "..") {
+			continue
+		}
+		if subDir == "." {
+			subDir = ""
+		}
+		hash := sha256.Sum256([]byte(path))
+		name := filepath.Base(path) + "-" + hex.EncodeToString(hash[:4])
+		return filepath.Join(cfg.IndexDir, subDir, cfg.Name+".checkpoints", name), true
+	}
+	return "", false
+}
+
+// writeCheckpoints writes the checkpoints taken from the data file of entry
+// to filename's ".new" file, and syncs it. WriteOut renames it into place.
+func writeCheckpoints(filename string, entry Entry, checkpoints []processor.Checkpoint) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return "", err
+	}
+
+	tmpfn := filename + ".new"
+	f, err := os.Create(tmpfn)
+	if err != nil {
+		return "", err
+	}
+
+	modified, _ := tf_time.MarshalTime(entry.Modified)
+	w := csv.NewWriter(f)
+	w.Write([]string{checkpointsMagic, "version=1",
+		"modified=" + string(modified),
+		"size=" + strconv.FormatInt(entry.Size, 10)})
+	for _, cp := range checkpoints {
+		before, _ := tf_time.MarshalTime(cp.Before)
+		w.Write([]string{strconv.FormatInt(cp.Offset, 10), string(before)})
+	}
+	w.Flush()
+
+	err = w.Error()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpfn)
+		return "", fmt.Errorf("Could not write checkpoints %s: %s", tmpfn, err)
+	}
+	return tmpfn, nil
+}
+
+// isCheckpointsDir reports whether dir holds the checkpoints of cfg.
+func isCheckpointsDir(cfg *config.Configuration, dir string) bool {
+	return filepath.Base(dir) == cfg.Name+".checkpoints"
+}
+
+// Checkpoints returns the checkpoints taken from the data file of entry when
+// it was indexed. It returns nil if there aren't any, or if the file has
+// changed since.
+func Checkpoints(cfg *config.Configuration, entry Entry) ([]processor.Checkpoint, error) {
+	filename, ok := checkpointsFilename(cfg, entry.Path)
+	if !ok {
+		return nil, nil
+	}
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil || len(header) < 1 || header[0] != checkpointsMagic {
+		return nil, fmt.Errorf("Bad formatting in checkpoints %s", filename)
+	}
+	for _, field := range header[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "modified":
+			modified, err := tf_time.UnmarshalTime([]byte(kv[1]))
+			if err != nil || !modified.Equal(entry.Modified) {
+				return nil, nil
+			}
+		case "size":
+			if kv[1] != strconv.FormatInt(entry.Size, 10) {
+				return nil, nil
+			}
+		}
+	}
+
+	checkpoints := []processor.Checkpoint{}
+	for {
+		recs, err := r.Read()
+		if err == io.EOF {
+			return checkpoints, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(recs) < 2 {
+			return nil, fmt.Errorf("Bad formatting in checkpoints %s", filename)
+		}
+
+		cp := processor.Checkpoint{}
+		if cp.Offset, err = strconv.ParseInt(recs[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("Bad offset in checkpoints %s: %s", filename, err)
+		}
+		if cp.Before, err = tf_time.UnmarshalTime([]byte(recs[1])); err != nil {
+			return nil, fmt.Errorf("Bad time in checkpoints %s: %s", filename, err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+}
+
+// SeekOffset returns the offset in the decompressed data of entry's data
+// file that a reader looking for records at or after t can start at. It's 0
+// if no checkpoints were taken.
+func SeekOffset(cfg *config.Configuration, entry Entry, t time.Time) (int64, error) {
+	checkpoints, err := Checkpoints(cfg, entry)
+	if err != nil {
+		return 0, err
+	}
+	return processor.SeekOffset(checkpoints, t), nil
+}
+
+// OpenAt opens entry's data file, decompressed, at SeekOffset(cfg, entry, t).
+// Data files with a header (like pcap) start without it.
+func OpenAt(cfg *config.Configuration, entry Entry, t time.Time) (io.ReadCloser, error) {
+	offset, err := SeekOffset(cfg, entry, t)
+	if err != nil {
+		return nil, err
+	}
+	return processor.OpenAt(entry.Path, offset)
+}
@@ -0,0 +1,127 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"timefind/internal/fixture"
+)
+
+func TestCheckpoints(t *testing.T) {
+	cfg := fixture.Source(t, "test", "fsdb_time_col_1", nil)
+	cfg.Checkpoints = 100
+
+	var data strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&data, "%d.5\trecord %d\n", 1436000000+i, i)
+	}
+	filename := filepath.Join(cfg.Paths[0], "a.fsdb")
+	if err := os.WriteFile(filename, []byte(data.String()), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cpfn, _ := checkpointsFilename(cfg, filename)
+
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is published until WriteOut.
+	if _, err := os.Stat(filepath.Dir(cpfn)); !os.IsNotExist(err) {
+		t.Errorf("checkpoints written by Update: %v", err)
+	}
+	if err := idx.WriteOut(); err != nil {
+		t.Fatal(err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(cpfn), "*.new")); len(matches) > 0 {
+		t.Errorf("left behind %v", matches)
+	}
+
+	entry := idx.entries[filename]
+	checkpoints, err := Checkpoints(cfg, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) < 2 {
+		t.Fatalf("got %d checkpoints, expected more", len(checkpoints))
+	}
+	for _, cp := range checkpoints {
+		// Every record before the checkpoint is no later than Before,
+		// which is the time of the one just before it.
+		before := data.String()[:cp.Offset]
+		lines := strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+		var sec int64
+		fmt.Sscanf(lines[len(lines)-1], "%d.5", &sec)
+		if !cp.Before.Equal(time.Unix(sec, 500000000)) {
+			t.Errorf("checkpoint at %d is before %s, expected %d.5", cp.Offset, cp.Before, sec)
+		}
+	}
+
+	// Reading from a checkpoint skips the records before it.
+	r, err := OpenAt(cfg, entry, time.Unix(1436000040, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) == data.Len() || !strings.HasSuffix(data.String(), string(rest)) ||
+		!strings.Contains(string(rest), "record 40\n") {
+		t.Errorf("OpenAt read %d of %d bytes", len(rest), data.Len())
+	}
+
+	// Checkpoints go with their data file.
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cpfn); err != nil {
+		t.Errorf("checkpoints removed before WriteOut: %v", err)
+	}
+	if err := idx.WriteOut(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cpfn); !os.IsNotExist(err) {
+		t.Errorf("checkpoints of a missing file not removed: %v", err)
+	}
+}
+
+func TestRemoveStaleCheckpoints(t *testing.T) {
+	cfg := fixture.Source(t, "test", "fsdb_time_col_1", nil)
+	dir := filepath.Join(cfg.IndexDir, "2015", cfg.Name+".checkpoints")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]bool{ // Whether each one is stale
+		indexFilename(cfg, "", FormatCSV) + ".new":        true,
+		indexFilename(cfg, "2015", FormatBinary) + ".new": true,
+		filepath.Join(dir, "a.fsdb-01234567.new"):         true,
+		filepath.Join(dir, "a.fsdb-01234567"):             false,
+		filepath.Join(cfg.IndexDir, "2015", "x.new"):      false,
+	}
+	for filename := range files {
+		if err := os.WriteFile(filename, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := RemoveStale(cfg); err != nil || n != 3 {
+		t.Errorf("RemoveStale removed %d (%v), expected 3", n, err)
+	}
+	for filename, stale := range files {
+		if _, err := os.Stat(filename); os.IsNotExist(err) != stale {
+			t.Errorf("%s: removed is %v, expected %v", filename, os.IsNotExist(err), stale)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package index
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"timefind/config"
+	tf_time "timefind/time"
+)
+
+// Index file formats, selected by the "indexFormat" configuration setting.
+const (
+	FormatCSV    = "csv"    // NAME.csv, the default
+	FormatBinary = "binary" // NAME.tfi, see binary.go
+)
+
+func indexFormat(cfg *config.Configuration) (string, error) {
+	switch cfg.IndexFormat {
+	case "", FormatCSV:
+		return FormatCSV, nil
+	case FormatBinary:
+		return FormatBinary, nil
+	}
+	return "", fmt.Errorf("Configuration specified unknown index format %q", cfg.IndexFormat)
+}
+
+func otherFormat(format string) string {
+	if format == FormatBinary {
+		return FormatCSV
+	}
+	return FormatBinary
+}
+
+func indexFilename(cfg *config.Configuration, subDir string, format string) string {
+	ext := ".csv"
+	if format == FormatBinary {
+		ext = ".tfi"
+	}
+	return filepath.Join(cfg.IndexDir, subDir, cfg.Name+ext)
+}
+
+/*
+  CSV indexes start with a header row describing the rest of the file:
+
+    #timefind-index,version=2,type=pcap,time=unix,columns=path earliest ...
+
+  "type" is the processor the index was built with, "time" is how the
+  timestamps are written ("unix" or "rfc3339") and "columns" names the
+  columns of every following row. Unknown keys are ignored.
+
+  Version 1 files have no header. Their columns are path, earliest, latest
+  and, optionally, modified.
+*/
+
+const (
+	csvMagic   = "#timefind-index"
+	csvVersion = 2
+)
+
+var csvColumns = []string{
+	"path", "earliest", "latest", "modified", "parsed", "skipped", "failed",
+	"size", "inode", "fingerprint", "uncompressed", "coverage",
+}
+
+// An indexHeader describes an index file, as read from its header.
+type indexHeader struct {
+	Version int
+	Type    string   // The processor type the index was built with
+	Time    string   // The time encoding: "unix" or "rfc3339"
+	Columns []string // The name of each column (CSV only)
+}
+
+func parseCSVHeader(recs []string, filename string) (hdr indexHeader, err error) {
+	for _, field := range recs[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return hdr, fmt.Errorf("Bad header field %q in index %s", field, filename)
+		}
+		switch kv[0] {
+		case "version":
+			if hdr.Version, err = strconv.Atoi(kv[1]); err != nil {
+				return hdr, fmt.Errorf("Bad version in index %s: %s", filename, err)
+			}
+		case "type":
+			hdr.Type = kv[1]
+		case "time":
+			hdr.Time = kv[1]
+		case "columns":
+			hdr.Columns = strings.Fields(kv[1])
+		}
+	}
+
+	switch {
+	case hdr.Version > csvVersion:
+		return hdr, fmt.Errorf("Index %s is version %d, but we only know up to version %d",
+			filename, hdr.Version, csvVersion)
+	case hdr.Time != "unix" && hdr.Time != "rfc3339":
+		return hdr, fmt.Errorf("Unknown time encoding %q in index %s", hdr.Time, filename)
+	case len(hdr.Columns) < 3 || hdr.Columns[0] != "path":
+		return hdr, fmt.Errorf("Bad columns in index %s", filename)
+	}
+
+	return hdr, nil
+}
+
+// legacyCSVColumns returns the columns of a row from a version 1 index.
+func legacyCSVColumns(n int) []string {
+	if n >= 4 {
+		return csvColumns[:4]
+	}
+	return csvColumns[:3]
+}
+
+func readCSV(filename string) ([]Entry, indexHeader, error) {
+	hdr := indexHeader{Version: 1, Time: "unix"}
+
+	// Open the index file for reading.
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, hdr, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+
+	entries := []Entry{}
+
+	// Read in all the existing entries
+	for first := true; ; first = false {
+		recs, err := cr.Read()
+		switch err {
+		case nil:
+		case io.EOF:
+			return entries, hdr, nil
+		default:
+			return nil, hdr, err
+		}
+
+		if first && recs[0] == csvMagic {
+			if hdr, err = parseCSVHeader(recs, filename); err != nil {
+				return nil, hdr, err
+			}
+			continue
+		}
+
+		columns := hdr.Columns
+		if hdr.Version == 1 {
+			// The old format didn't include modification times, or
+			// record counts.
+			columns = legacyCSVColumns(len(recs))
+		}
+		if len(recs) < 3 || len(recs) < len(columns) {
+			return nil, hdr, fmt.Errorf("Bad formatting in index %s", filename)
+		}
+
+		entry := Entry{}
+		for i, column := range columns {
+			if err := setColumn(&entry, column, recs[i]); err != nil {
+				return nil, hdr, fmt.Errorf("Bad %s in index %s: %s", column, filename, err)
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+}
+
+// setColumn sets the field of entry named by column. Unknown columns, from
+// a newer version of the same index format, are ignored.
+func setColumn(entry *Entry, column string, value string) (err error) {
+	switch column {
+	case "path":
+		entry.Path = value
+	case "earliest":
+		entry.Period.Earliest, err = tf_time.UnmarshalTime([]byte(value))
+	case "latest":
+		entry.Period.Latest, err = tf_time.UnmarshalTime([]byte(value))
+	case "modified":
+		entry.Modified, err = tf_time.UnmarshalTime([]byte(value))
+	case "parsed":
+		entry.Stats.Parsed, err = strconv.ParseInt(value, 10, 64)
+	case "skipped":
+		entry.Stats.Skipped, err = strconv.ParseInt(value, 10, 64)
+	case "failed":
+		entry.Stats.Failed, err = strconv.ParseInt(value, 10, 64)
+	case "size":
+		entry.Size, err = strconv.ParseInt(value, 10, 64)
+	case "inode":
+		entry.Inode, err = strconv.ParseUint(value, 10, 64)
+	case "fingerprint":
+		entry.Fingerprint = value
+	case "uncompressed":
+		entry.Uncompressed, err = strconv.ParseInt(value, 10, 64)
+	case "coverage":
+		entry.Coverage, err = parseCoverage(value)
+	}
+	return err
+}
+
+func writeCSV(w io.Writer, entries []Entry, typ string) error {
+	csv_file := csv.NewWriter(w)
+
+	header := []string{csvMagic,
+		"version=" + strconv.Itoa(csvVersion),
+		"type=" + typ,
+		"time=unix",
+		"columns=" + strings.Join(csvColumns, " ")}
+	if err := csv_file.Write(header); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		Earliest_bytes, _ := tf_time.MarshalTime(entry.Period.Earliest)
+		Latest_bytes, _ := tf_time.MarshalTime(entry.Period.Latest)
+		Modified_bytes, _ := tf_time.MarshalTime(entry.Modified)
+
+		recs := []string{entry.Path,
+			string(Earliest_bytes),
+			string(Latest_bytes),
+			string(Modified_bytes),
+			strconv.FormatInt(entry.Stats.Parsed, 10),
+			strconv.FormatInt(entry.Stats.Skipped, 10),
+			strconv.FormatInt(entry.Stats.Failed, 10),
+			strconv.FormatInt(entry.Size, 10),
+			strconv.FormatUint(entry.Inode, 10),
+			entry.Fingerprint,
+			strconv.FormatInt(entry.Uncompressed, 10),
+			formatCoverage(entry.Coverage)}
+		err := csv_file.Write(recs)
+		if err != nil {
+			return err
+		}
+	}
+
+	csv_file.Flush()
+	return csv_file.Error()
+}
+
+// formatCoverage writes intervals as space-separated "earliest/latest" pairs.
+func formatCoverage(intervals []tf_time.Times) string {
+	pairs := make([]string, len(intervals))
+	for i, interval := range intervals {
+		earliest, _ := tf_time.MarshalTime(interval.Earliest)
+		latest, _ := tf_time.MarshalTime(interval.Latest)
+		pairs[i] = string(earliest) + "/" + string(latest)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func parseCoverage(value string) ([]tf_time.Times, error) {
+	var intervals []tf_time.Times
+	for _, pair := range strings.Fields(value) {
+		times := strings.Split(pair, "/")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("bad interval %q", pair)
+		}
+
+		earliest, err := tf_time.UnmarshalTime([]byte(times[0]))
+		if err != nil {
+			return nil, err
+		}
+		latest, err := tf_time.UnmarshalTime([]byte(times[1]))
+		if err != nil {
+			return nil, err
+		}
+		intervals = append(intervals, tf_time.Times{Earliest: earliest, Latest: latest})
+	}
+	return intervals, nil
+}
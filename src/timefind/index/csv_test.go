@@ -0,0 +1,155 @@
+package index
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"timefind/internal/fixture"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	want := testEntries()
+	filename := filepath.Join(t.TempDir(), "test.csv")
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, want, "pcap"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, hdr, err := readCSV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Version != csvVersion || hdr.Type != "pcap" || hdr.Time != "unix" {
+		t.Errorf("got header %+v", hdr)
+	}
+	if !reflect.DeepEqual(byPath(got), byPath(want)) {
+		t.Errorf("got %+v\nexpected %+v", got, want)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		version  int
+		modified int64  // Of the only entry, in Unix seconds
+		err      string // A substring of the expected error, if any
+	}{
+		// Indexes from before the header.
+		{"version 1", "/data/a,1436000000.000000000,1436000100.000000000\n", 1, 0, ""},
+		{"version 1 modified",
+			"/data/a,1436000000.000000000,1436000100.000000000,1436000200.000000000\n",
+			1, 1436000200, ""},
+		{"version 1 short", "/data/a,1436000000.000000000\n", 1, 0, "Bad formatting"},
+
+		{"version 2",
+			"#timefind-index,version=2,type=pcap,time=unix,columns=path earliest latest modified\n" +
+				"/data/a,1436000000.000000000,1436000100.000000000,1436000200.000000000\n",
+			2, 1436000200, ""},
+		{"rfc3339",
+			"#timefind-index,version=2,type=pcap,time=rfc3339,columns=path earliest latest modified\n" +
+				"/data/a,2015-07-04T08:53:20Z,2015-07-04T08:55:00Z,2015-07-04T08:56:40Z\n",
+			2, 1436000200, ""},
+		{"unknown columns",
+			"#timefind-index,version=2,type=pcap,time=unix,columns=path earliest latest modified colour\n" +
+				"/data/a,1436000000.000000000,1436000100.000000000,1436000200.000000000,red\n",
+			2, 1436000200, ""},
+		{"newer version",
+			"#timefind-index,version=3,type=pcap,time=unix,columns=path earliest latest\n",
+			0, 0, "only know up to"},
+		{"bad time encoding",
+			"#timefind-index,version=2,type=pcap,time=julian,columns=path earliest latest\n",
+			0, 0, "Unknown time"},
+		{"bad columns",
+			"#timefind-index,version=2,type=pcap,time=unix,columns=earliest path latest\n",
+			0, 0, "Bad columns"},
+		{"bad time",
+			"#timefind-index,version=2,type=pcap,time=unix,columns=path earliest latest\n" +
+				"/data/a,yesterday,1436000100.000000000\n",
+			0, 0, "Bad earliest"},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		filename := filepath.Join(dir, "test.csv")
+		if err := os.WriteFile(filename, []byte(test.data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		entries, hdr, err := readCSV(filename)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, expected one with %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if hdr.Version != test.version || len(entries) != 1 {
+			t.Errorf("%s: got version %d and %d entries", test.name, hdr.Version, len(entries))
+			continue
+		}
+		entry := entries[0]
+		if entry.Path != "/data/a" || !entry.Period.Earliest.Equal(time.Unix(1436000000, 0)) ||
+			!entry.Period.Latest.Equal(time.Unix(1436000100, 0)) {
+			t.Errorf("%s: got %+v", test.name, entry)
+		}
+		if test.modified != 0 && !entry.Modified.Equal(time.Unix(test.modified, 0)) ||
+			test.modified == 0 && !entry.Modified.IsZero() {
+			t.Errorf("%s: got modified %s, expected %d", test.name, entry.Modified, test.modified)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	cfg := fixture.Source(t, "test", "pcap", nil)
+	if err := os.MkdirAll(filepath.Join(cfg.IndexDir, "2015"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	// A version 1 tree: a directory entry, and its sub index.
+	files := map[string]string{
+		"test.csv":      "2015,1436000000.000000000,1436000100.000000000,1436000200.000000000\n",
+		"2015/test.csv": "/data/a,1436000000.000000000,1436000100.000000000,1436000200.000000000\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(cfg.IndexDir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.LoadAll()
+	if n := idx.Outdated(); n != 2 {
+		t.Errorf("%d outdated, expected 2", n)
+	}
+	if err := idx.WriteOut(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range files {
+		_, hdr, err := readCSV(filepath.Join(cfg.IndexDir, name))
+		if err != nil || hdr.Version != csvVersion {
+			t.Errorf("%s: version %d after migrating (%v)", name, hdr.Version, err)
+		}
+	}
+	idx, err = NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := idx.FindLogs(time.Unix(1436000050, 0), time.Unix(1436000050, 0)); len(entries) != 1 ||
+		entries[0].Path != "/data/a" {
+		t.Errorf("FindLogs after migrating: %+v", entries)
+	}
+}
@@ -1,14 +1,14 @@
 package index
 
 import (
-	"encoding/csv"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"timefind/config"
@@ -20,16 +20,51 @@ type Entry struct {
 	Path     string
 	Period   tf_time.Times
 	Modified time.Time
+	Stats    processor.Stats // Record counts; summed over a directory's files.
+
+	// What the data file looked like when it was indexed (for an archive
+	// member, the archive). See change.go. For a directory, Size is the
+	// total size of the data files below it, counting each archive once.
+	Size        int64
+	Inode       uint64
+	Fingerprint string // Empty unless the source is configured to use them
+
+	// The size of the data once decompressed (for an archive member, its
+	// size in the archive), summed over a directory's files. Zero if it
+	// isn't known; see processor.UncompressedSize.
+	Uncompressed int64
+
+	// The parts of Period that records actually fall in, if the source
+	// is configured with "coverage". Empty means all of it.
+	Coverage []tf_time.Times
+
 	subIndex *Index
 }
 
 type Index struct {
-	Filename string                // The name of this index
-	Config   *config.Configuration // The configuration data for this index.
-	subDir   string                // The sub directory of index files that this index applies to.
-	entries  map[string]Entry      // It's slice of entries
-	Period   tf_time.Times         // The earliest and latest item within this entire index.
-	Modified time.Time             // When this index was last modified.
+	Filename     string                // The name of this index
+	Config       *config.Configuration // The configuration data for this index.
+	subDir       string                // The sub directory of index files that this index applies to.
+	entries      map[string]Entry      // It's slice of entries
+	Period       tf_time.Times         // The earliest and latest item within this entire index.
+	Stats        processor.Stats       // Record counts over this entire index.
+	Size         int64                 // Bytes of data files in this entire index.
+	Uncompressed int64                 // Bytes of decompressed data in this entire index.
+	Modified     time.Time             // When this index was last modified.
+	Force        bool                  // Reprocess every data file on Update, changed or not.
+	corrupt      int                   // Corrupt data files skipped by the last Update.
+	outdated     bool                  // Read from an older version or the other format.
+	duplicates   []Entry               // Entries dropped for a later one of the same path.
+
+	// Checkpoints taken by Update, by data file, for WriteOut to publish
+	// along with the index. nil means the data file's old checkpoints are
+	// to be removed.
+	checkpoints map[string][]processor.Checkpoint
+
+	// The entries sorted by the start of their period, as an implicit
+	// interval tree for Find (see sortEntries). nil until first needed.
+	byStart   []string
+	maxLatest []time.Time
 }
 
 // TODO propagate this option from timefind.go
@@ -55,103 +90,218 @@ func subIndex(cfg *config.Configuration,
 	// cfg - Configuration file
 	// subDir - What subDirectory we're on in our indexing.
 
-	filename := filepath.Join(cfg.IndexDir, subDir, cfg.Name+".csv")
+	format, err := indexFormat(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	idx := &Index{
-		Filename: filename,
-		Config:   cfg,
-		subDir:   subDir,
-		entries:  map[string]Entry{},
-		Period:   tf_time.Times{},
-		Modified: time.Time{},
+		Filename:    indexFilename(cfg, subDir, format),
+		Config:      cfg,
+		subDir:      subDir,
+		entries:     map[string]Entry{},
+		Period:      tf_time.Times{},
+		Modified:    time.Time{},
+		checkpoints: map[string][]processor.Checkpoint{},
 	}
 
-	// Open the index file for reading.
-	f, err := os.Open(filename)
-	if err != nil {
-		return idx, nil
+	if _, err := coverageGranularity(cfg); err != nil {
+		return nil, err
 	}
-	defer f.Close()
-
-	// Make sure a reasonable processor exists
-	if _, ok := processor.Processors[cfg.Type]; ok != true {
-		return nil, errors.New("Configuration specified unknown data type.")
+	if _, err := SourceLocation(cfg); err != nil {
+		return nil, err
 	}
 
-	idxStat, err := os.Stat(filename)
-	idx.Modified = idxStat.ModTime()
+	// The processor isn't needed to read an index, only to Update it (see
+	// lookupProcessor), so indexes built by custom processors can be
+	// searched by any timefind.
+
+	// Read the index in whichever format we find it, preferring the one
+	// we're configured to write. The next WriteOut converts it.
+	for _, f := range []string{format, otherFormat(format)} {
+		filename := indexFilename(cfg, subDir, f)
 
-	cr := csv.NewReader(f)
-	idx.Filename = filename
+		idxStat, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+		idx.Modified = idxStat.ModTime()
 
-	// Read in all the existing entries
-	for {
-		recs, err := cr.Read()
-		switch err {
-		case nil:
-		case io.EOF:
-			return idx, nil
-		default:
+		var entries []Entry
+		var hdr indexHeader
+		if f == FormatBinary {
+			entries, hdr, err = readBinary(filename)
+			idx.outdated = f != format || hdr.Version < binaryVersion
+		} else {
+			entries, hdr, err = readCSV(filename)
+			idx.outdated = f != format || hdr.Version < csvVersion
+		}
+		if err != nil {
 			return nil, err
 		}
-		if len(recs) < 3 {
-			return nil, fmt.Errorf("Bad formatting in index %s", filename)
+		if hdr.Type != "" && hdr.Type != cfg.Type {
+			log.Printf("%s was built by the %s processor, but %s is configured as type %s",
+				filename, hdr.Type, cfg.Name, cfg.Type)
 		}
 
-		entry := Entry{}
-		entry.Path = recs[0]
+		for _, entry := range entries {
+			// Sub indexes (entries for subdirectories) aren't read until
+			// they're needed; see loadSubIndex.
 
-		if entry.Period.Earliest, err = tf_time.UnmarshalTime([]byte(recs[1])); err != nil {
-			return nil, err
-		}
+			vlog("idx - %v", entry.Period)
 
-		if entry.Period.Latest, err = tf_time.UnmarshalTime([]byte(recs[2])); err != nil {
-			return nil, err
+			// A path listed more than once keeps its most recently
+			// modified entry (or its last, if they tie). Check reports
+			// the others.
+			if prev, dup := idx.entries[entry.Path]; dup {
+				if entry.Modified.Before(prev.Modified) {
+					prev, entry = entry, prev
+				}
+				idx.duplicates = append(idx.duplicates, prev)
+			}
+			idx.entries[entry.Path] = entry
+		}
+		for _, entry := range idx.entries {
+			idx.Period.Union(entry.Period)
+			idx.Stats.Sum(entry.Stats)
 		}
+		break
+	}
+
+	idx.sumSizes()
+
+	return idx, nil
+}
+
+// sumSizes sets the index's total sizes from its entries. Archive members
+// all have the size of their archive, so each archive is only counted once.
+func (idx *Index) sumSizes() {
+	entries := make([]Entry, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		entries = append(entries, entry)
+	}
+	idx.Size, idx.Uncompressed = SumSizes(entries)
+}
 
-		// The old format didn't include modification times
-		if len(recs) == 4 {
-			if entry.Modified, err = tf_time.UnmarshalTime([]byte(recs[3])); err != nil {
-				return nil, err
+// SumSizes returns the total size and uncompressed size of entries, counting
+// each archive once.
+func SumSizes(entries []Entry) (size int64, uncompressed int64) {
+	archives := map[string]bool{}
+	for _, entry := range entries {
+		if archive, _, ok := processor.SplitArchivePath(entry.Path); ok {
+			if archives[archive] {
+				size -= entry.Size
 			}
+			archives[archive] = true
 		}
+		size += entry.Size
+		uncompressed += entry.Uncompressed
+	}
+	return size, uncompressed
+}
 
-		if idx.Period.Earliest.IsZero() ||
-			entry.Period.Earliest.Before(idx.Period.Earliest) {
-			idx.Period.Earliest = entry.Period.Earliest
-		}
-		if idx.Period.Latest.IsZero() ||
-			entry.Period.Latest.After(idx.Period.Latest) {
-			idx.Period.Latest = entry.Period.Latest
-		}
+// isDir reports whether the entry refers to a subdirectory with its own index,
+// rather than a data file.
+func (entry *Entry) isDir() bool {
+	// If the file path isn't absolute, this should be a subdirectory.
+	return filepath.IsAbs(entry.Path) == false
+}
 
-		// If the file path isn't absolute, this should be a subdirectory.
-		if filepath.IsAbs(entry.Path) == false {
-			subDir := filepath.Join(idx.subDir, entry.Path)
-			subidx_path := filepath.Join(cfg.IndexDir, subDir)
+// The most intervals an entry's Coverage is reduced to.
+const maxCoverage = 64
 
-			// Make sure the index subdirectory exists and is a directory.
-			info, err := os.Stat(subidx_path)
-			if (err == nil || os.IsExist(err)) && info.IsDir() {
-				subidx, err := subIndex(cfg, subDir)
-				if err != nil {
-					log.Print("Could not read index from subdirectory: ", subDir)
-				}
-				entry.subIndex = subidx
-			}
+func coverageGranularity(cfg *config.Configuration) (time.Duration, error) {
+	if cfg.Coverage == "" {
+		return 0, nil
+	}
+	granularity, err := time.ParseDuration(cfg.Coverage)
+	if err != nil || granularity <= 0 {
+		return 0, fmt.Errorf("Configuration specified bad coverage %q", cfg.Coverage)
+	}
+	return granularity, nil
+}
+
+// lookupProcessor returns the processor configured for cfg, with its options
+// checked.
+func lookupProcessor(cfg *config.Configuration) (processor.Processor, error) {
+	p, ok := processor.Lookup(cfg.Type)
+	if !ok {
+		return nil, errors.New("Configuration specified unknown data type.")
+	}
+	if err := processor.CheckOptions(p, cfg.Options); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SourceLocation returns the time zone of timestamps without one in the
+// source's data files.
+func SourceLocation(cfg *config.Configuration) (*time.Location, error) {
+	loc, err := tf_time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Configuration specified bad timezone %q", cfg.Timezone)
+	}
+	return loc, nil
+}
+
+// hasRecordsIn reports whether the entry may have records between earliest
+// and latest, going by its Coverage. Its Period is assumed to overlap them.
+func (entry *Entry) hasRecordsIn(earliest time.Time, latest time.Time) bool {
+	if len(entry.Coverage) == 0 {
+		return true
+	}
+	for _, interval := range entry.Coverage {
+		if !interval.Latest.Before(earliest) && !interval.Earliest.After(latest) {
+			return true
 		}
+	}
+	return false
+}
 
-		vlog("idx - ", entry.Period)
-		idx.entries[recs[0]] = entry
+// loadSubIndex returns the sub index for the directory entry at path, reading
+// it from disk the first time it's asked for. It returns nil if the
+// subdirectory has no index.
+func (idx *Index) loadSubIndex(path string) *Index {
+	entry := idx.entries[path]
+	if entry.subIndex != nil || !entry.isDir() {
+		return entry.subIndex
 	}
 
-	return idx, nil
+	subDir := filepath.Join(idx.subDir, entry.Path)
+	subidx_path := filepath.Join(idx.Config.IndexDir, subDir)
+
+	// Make sure the index subdirectory exists and is a directory.
+	info, err := os.Stat(subidx_path)
+	if err != nil || !info.IsDir() {
+		return nil
+	}
+
+	subidx, err := subIndex(idx.Config, subDir)
+	if err != nil {
+		log.Print("Could not read index from subdirectory: ", subDir)
+		return nil
+	}
+
+	entry.subIndex = subidx
+	idx.entries[path] = entry
+	return subidx
 }
 
 // Update all the records for this index and all sub indexes.
 func (idx *Index) Update() error {
+	process, err := lookupProcessor(idx.Config)
+	if err != nil {
+		return err
+	}
+
 	for path, _ := range idx.entries {
-		_, err := os.Stat(path)
+		// Archive members are only as missing as the archive they're in.
+		statPath := path
+		if archive, _, ok := processor.SplitArchivePath(path); ok {
+			statPath = archive
+		}
+
+		_, err := os.Stat(statPath)
 		if err != nil {
 			// The path probably doesn't exist, or is otherwise inaccessible.
 			// If the path corresponds to a subdir, it won't be a full path
@@ -169,17 +319,23 @@ func (idx *Index) Update() error {
 				log.Print("Removing missing file", path)
 				// It wasn't a directory, so it looks like it's missing.
 				delete(idx.entries, path)
+				idx.checkpoints[path] = nil
 			}
 		}
 	}
 
 	// Reset the index's time period
 	idx.Period = tf_time.Times{}
+	idx.Stats = processor.Stats{}
+	idx.corrupt = 0
 
 	subDirs := make(map[string]bool)
 
-	// We tested to make sure this existed on instantiation
-	process, _ := processor.Processors[idx.Config.Type]
+	opts := processor.Options(idx.Config.Options)
+	granularity, _ := coverageGranularity(idx.Config)
+	loc, _ := SourceLocation(idx.Config)
+	extras := processor.Extras{Coverage: granularity, Checkpoints: idx.Config.Checkpoints,
+		Location: loc}
 
 	// Process each data directory in our cfgs
 	for _, base_dir := range idx.Config.Paths {
@@ -204,12 +360,27 @@ func (idx *Index) Update() error {
 			//   (1) matches the include pattern,
 			//   (2) does not match the exclude pattern
 			if match := idx.Config.Match(info.Name()); match == true {
+				if idx.Config.Archives && processor.IsArchive(info.Name()) {
+					if err := idx.updateArchive(full_path, info, process, opts, extras); err != nil {
+						return err
+					}
+					continue
+				}
+
+				id, err := idx.identify(full_path, info)
+				if err != nil {
+					return err
+				}
+
 				entry, ok := idx.entries[full_path]
 				if ok == true {
-					if info.ModTime().Equal(entry.Modified) ||
-						info.ModTime().Before(entry.Modified) {
+					if !idx.Force && entry.isCurrent(id) {
 						// Make sure to include this time in the index period.
 						idx.Period.Union(entry.Period)
+						idx.Stats.Sum(entry.Stats)
+						// Fill in anything an older index didn't record.
+						entry.setID(id)
+						idx.entries[full_path] = entry
 						continue // This file hasn't been updated since it was last indexed.
 					}
 				} else {
@@ -218,16 +389,35 @@ func (idx *Index) Update() error {
 					entry.Path = full_path
 				}
 
-				entry.Modified = info.ModTime()
+				entry.setID(id)
 
 				log.Print("Processing data file ", full_path)
-				period, err := process(full_path)
-				if err != nil {
+				res, err := processor.ProcessFile(process, full_path, opts, extras)
+				if cerr, ok := err.(*processor.CorruptFileError); ok {
+					// Don't let a damaged file look like a valid (if short)
+					// one. Drop it so that it's retried on the next update.
+					log.Print("Skipping corrupt data file: ", cerr)
+					delete(idx.entries, full_path)
+					idx.checkpoints[full_path] = nil
+					idx.corrupt++
+					continue
+				} else if err != nil {
 					return err
 				}
 
-				entry.Period = period
-				idx.Period.Union(period)
+				entry.Period = res.Period
+				entry.Stats = res.Stats
+				entry.Coverage = res.Coverage.Intervals(res.Period, maxCoverage)
+				entry.Uncompressed = 0
+				if n, ok, err := processor.UncompressedSize(full_path); err == nil && ok {
+					entry.Uncompressed = n
+				}
+				idx.checkpoints[full_path] = nil
+				if res.Checkpoints != nil && len(res.Checkpoints.List) > 0 {
+					idx.checkpoints[full_path] = res.Checkpoints.List
+				}
+				idx.Period.Union(res.Period)
+				idx.Stats.Sum(res.Stats)
 
 				idx.entries[full_path] = entry
 			}
@@ -255,96 +445,533 @@ func (idx *Index) Update() error {
 			}
 		}
 
+		entry.subIndex.Force = idx.Force
 		err := entry.subIndex.Update()
 		if err != nil {
 			return err
 		}
 
 		entry.Period = entry.subIndex.Period
+		entry.Stats = entry.subIndex.Stats
+		entry.Size = entry.subIndex.Size
+		entry.Uncompressed = entry.subIndex.Uncompressed
 		idx.Period.Union(entry.Period)
+		idx.Stats.Sum(entry.Stats)
 		entry.Modified = entry.subIndex.Modified
 
 		idx.entries[dir] = entry
 	}
 
+	idx.sumSizes()
 	idx.Modified = time.Now().UTC()
+	idx.byStart = nil
+
+	return nil
+}
+
+// updateArchive indexes each member of the archive at path as its own entry,
+// named "path!member". Members are only reprocessed when the archive changes.
+func (idx *Index) updateArchive(path string, info os.FileInfo,
+	process processor.Processor, opts processor.Options, extras processor.Extras) error {
+
+	prefix := path + processor.ArchiveSep
+
+	id, err := idx.identify(path, info)
+	if err != nil {
+		return err
+	}
+
+	members := []Entry{}
+	current := !idx.Force
+	for p, entry := range idx.entries {
+		if strings.HasPrefix(p, prefix) {
+			members = append(members, entry)
+			if !entry.isCurrent(id) {
+				current = false
+			}
+		}
+	}
+
+	if current && len(members) > 0 {
+		for _, entry := range members {
+			idx.Period.Union(entry.Period)
+			idx.Stats.Sum(entry.Stats)
+			entry.setID(id)
+			idx.entries[entry.Path] = entry
+		}
+		return nil
+	}
+
+	// Start over, in case members were removed from the archive.
+	for _, entry := range members {
+		delete(idx.entries, entry.Path)
+	}
+
+	log.Print("Processing archive ", path)
+	added := []Entry{}
+	corrupt := false
+	var processErr error
+	err = processor.ProcessArchive(path, process, opts, extras,
+		func(member string, size int64, res processor.Result, err error) error {
+			if cerr, ok := err.(*processor.CorruptFileError); ok {
+				log.Print("Skipping corrupt data file: ", cerr)
+				idx.corrupt++
+				corrupt = true
+				return nil
+			} else if err != nil {
+				processErr = err
+				return err
+			}
+
+			entry := Entry{
+				Path:         prefix + member,
+				Period:       res.Period,
+				Stats:        res.Stats,
+				Uncompressed: size,
+				Coverage:     res.Coverage.Intervals(res.Period, maxCoverage),
+			}
+			entry.setID(id)
+			added = append(added, entry)
+			return nil
+		})
+	if processErr != nil {
+		return processErr
+	} else if err != nil {
+		// The archive itself is damaged (e.g., truncated). Like a corrupt
+		// data file, leave out whatever was read from it, so that it's
+		// retried on the next update.
+		log.Printf("Skipping corrupt archive %s: %s", path, err)
+		idx.corrupt++
+		return nil
+	}
 
+	for _, entry := range added {
+		if corrupt {
+			// Keep the good members, but forget when the archive was
+			// indexed, so that it's processed again (and the corrupt
+			// members retried) on the next update.
+			entry.Modified = time.Time{}
+		}
+		idx.entries[entry.Path] = entry
+		idx.Period.Union(entry.Period)
+		idx.Stats.Sum(entry.Stats)
+	}
 	return nil
 }
 
+// CorruptFiles returns the number of data files in this index and all loaded
+// sub indexes that were skipped by the last Update because they were corrupt.
+func (idx *Index) CorruptFiles() int {
+	n := idx.corrupt
+	for _, entry := range idx.entries {
+		if entry.subIndex != nil {
+			n += entry.subIndex.CorruptFiles()
+		}
+	}
+	return n
+}
+
+// sortEntries builds the sorted view of the entries that Find searches.
+//
+// byStart is also read as a balanced binary tree: the root of byStart[lo:hi]
+// is its middle element, mid = (lo+hi)/2, with byStart[lo:mid] to its left
+// and byStart[mid+1:hi] to its right. maxLatest[mid] is the latest end of
+// any period in that subtree, so a search can skip whole subtrees that end
+// before the window (and, since they're sorted, everything right of an entry
+// that starts after it). Finding k matches out of n entries takes
+// O((k+1) log n), however long some of the periods are.
+func (idx *Index) sortEntries() {
+	idx.byStart = make([]string, 0, len(idx.entries))
+	for path := range idx.entries {
+		idx.byStart = append(idx.byStart, path)
+	}
+	sort.Slice(idx.byStart, func(i, j int) bool {
+		a, b := idx.entries[idx.byStart[i]], idx.entries[idx.byStart[j]]
+		if a.Period.Earliest.Equal(b.Period.Earliest) {
+			return a.Path < b.Path
+		}
+		return a.Period.Earliest.Before(b.Period.Earliest)
+	})
+
+	idx.maxLatest = make([]time.Time, len(idx.byStart))
+	idx.setMaxLatest(0, len(idx.byStart))
+}
+
+// setMaxLatest fills in maxLatest for the subtree byStart[lo:hi], and returns
+// its latest end.
+func (idx *Index) setMaxLatest(lo int, hi int) time.Time {
+	if lo >= hi {
+		return time.Time{}
+	}
+	mid := (lo + hi) / 2
+	latest := idx.entries[idx.byStart[mid]].Period.Latest
+	for _, l := range []time.Time{idx.setMaxLatest(lo, mid), idx.setMaxLatest(mid+1, hi)} {
+		if l.After(latest) {
+			latest = l
+		}
+	}
+	idx.maxLatest[mid] = latest
+	return latest
+}
+
+// overlapping calls visit, in order, with the index in byStart of every entry
+// in byStart[lo:hi] that might overlap [earliest, latest]: all of those that
+// do, and a few that don't.
+func (idx *Index) overlapping(lo int, hi int, earliest time.Time, latest time.Time,
+	visit func(i int)) {
+
+	if lo >= hi || idx.maxLatest[(lo+hi)/2].Before(earliest) {
+		return // Everything here ends too soon.
+	}
+	mid := (lo + hi) / 2
+	idx.overlapping(lo, mid, earliest, latest, visit)
+	if idx.entries[idx.byStart[mid]].Period.Earliest.After(latest) {
+		return // This, and everything after it, starts too late.
+	}
+	visit(mid)
+	idx.overlapping(mid+1, hi, earliest, latest, visit)
+}
+
+// How the period of a data file has to relate to the time window searched for.
+type Match int
+
+const (
+	MatchOverlaps Match = iota // Any time in common with the window
+	MatchWithin                // Entirely inside the window
+	MatchCovering              // Containing the whole window
+)
+
+// FindLogs returns the data file entries whose period overlaps [earliest,
+// latest], ordered by the start of their period within each directory. Only
+// the sub indexes of overlapping directories are read.
 func (idx *Index) FindLogs(earliest time.Time, latest time.Time) []Entry {
+	return idx.Find(tf_time.Times{Earliest: earliest, Latest: latest}, MatchOverlaps)
+}
+
+// Find returns the data file entries whose period matches window, ordered by
+// the start of their period within each directory. Both ends of periods and
+// of the window are included, so the files containing an instant t are those
+// covering [t, t].
+func (idx *Index) Find(window tf_time.Times, match Match) []Entry {
+	earliest, latest := window.Earliest, window.Latest
 	entries := []Entry{}
 
 	vlog("Find Earliest: %s Latest: %s", earliest, latest)
 
-	for _, entry := range idx.entries {
+	if idx.byStart == nil {
+		idx.sortEntries()
+	}
+
+	candidates := []string{}
+	idx.overlapping(0, len(idx.byStart), earliest, latest, func(i int) {
+		candidates = append(candidates, idx.byStart[i])
+	})
+
+	for _, path := range candidates {
+		entry := idx.entries[path]
 		vlog("Trying: %s, %s", entry.Period.Earliest, entry.Period.Latest)
-		switch {
-		case entry.Period.Latest.Before(earliest):
+		if !entry.Period.Overlaps(window) {
 			continue
-		case entry.Period.Earliest.After(latest):
+		}
+		if !entry.hasRecordsIn(earliest, latest) {
+			vlog("No records in %s between %s and %s", entry.Path, earliest, latest)
 			continue
 		}
 
-		vlog("Found %s", filepath.Join(idx.subDir, entry.Path))
-
-		if entry.subIndex != nil {
+		if entry.isDir() {
 			// This is a directory that needs to be searched recursively.
-			entries = append(entries, entry.subIndex.FindLogs(earliest, latest)...)
-		} else {
-			// Just a normal file
-			entries = append(entries, entry)
+			// Any file in it covering the window means it does too.
+			if match == MatchCovering && !entry.Period.Covers(window) {
+				continue
+			}
+			vlog("Found %s", filepath.Join(idx.subDir, entry.Path))
+			if subidx := idx.loadSubIndex(path); subidx != nil {
+				entries = append(entries, subidx.Find(window, match)...)
+			}
+			continue
 		}
 
+		switch {
+		case match == MatchWithin && !entry.Period.Within(window):
+			continue
+		case match == MatchCovering && !entry.Period.Covers(window):
+			continue
+		}
+		// Just a normal file
+		vlog("Found %s", filepath.Join(idx.subDir, entry.Path))
+		entries = append(entries, entry)
 	}
 
 	return entries
 }
 
+// FindWindows returns the data file entries that match any of windows, once
+// each, in the order they're first found. matched holds, for each entry, the
+// indexes in windows of the windows it matched.
+func (idx *Index) FindWindows(windows []tf_time.Times, match Match) (entries []Entry, matched [][]int) {
+	seen := map[string]int{}
+	for i, window := range windows {
+		for _, entry := range idx.Find(window, match) {
+			n, ok := seen[entry.Path]
+			if !ok {
+				n = len(entries)
+				seen[entry.Path] = n
+				entries = append(entries, entry)
+				matched = append(matched, nil)
+			}
+			matched[n] = append(matched[n], i)
+		}
+	}
+	return entries, matched
+}
+
+// LoadAll reads every sub index of this index that hasn't been read yet.
+func (idx *Index) LoadAll() {
+	for path, entry := range idx.entries {
+		if entry.isDir() {
+			if subidx := idx.loadSubIndex(path); subidx != nil {
+				subidx.LoadAll()
+			}
+		}
+	}
+}
+
+// Outdated returns the number of index files, among this index and the sub
+// indexes that have been read, that weren't in the newest version of the
+// configured format. WriteOut upgrades them.
+func (idx *Index) Outdated() int {
+	n := 0
+	if idx.outdated {
+		n++
+	}
+	for _, entry := range idx.entries {
+		if entry.subIndex != nil {
+			n += entry.subIndex.Outdated()
+		}
+	}
+	return n
+}
+
+// WriteOut writes this index, and every sub index that has been read, in the
+// configured format. An index file left over in the other format is removed.
+//
+// All of the new index files are written and synced first, and then renamed
+// into place together while holding the snapshot lock (see lock.go). An
+// error means some of the index may not have been written; the index files
+// on disk are still complete, either old or new.
 func (idx *Index) WriteOut() error {
+	pending := []pendingIndex{}
+	err := idx.writeNew(&pending)
+	if err != nil {
+		for _, p := range pending {
+			os.Remove(p.tmpfn)
+			for _, cp := range p.checkpoints {
+				if cp.tmpfn != "" {
+					os.Remove(cp.tmpfn)
+				}
+			}
+		}
+		return err
+	}
+
+	lock, err := lockPublish(idx.Config)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// Sub indexes come first, so a crash part way through leaves every
+	// parent pointing at sub indexes that are at least as new as it is.
+	dirs := map[string]bool{}
+	syncParents := func(dir string) {
+		for ; !dirs[dir]; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+			if dir == filepath.Clean(idx.Config.IndexDir) || dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	for _, p := range pending {
+		// Checkpoints are only used while they match their data file's
+		// entry, so they can go before or after the index.
+		for _, cp := range p.checkpoints {
+			if cp.tmpfn == "" {
+				err = os.Remove(cp.filename)
+			} else {
+				err = os.Rename(cp.tmpfn, cp.filename)
+			}
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("Could not write checkpoints %s: %s", cp.filename, err)
+			}
+			syncParents(filepath.Dir(cp.filename))
+		}
+		p.idx.checkpoints = map[string][]processor.Checkpoint{}
+
+		if err := os.Rename(p.tmpfn, p.idx.Filename); err != nil {
+			return fmt.Errorf("Could not write index %s: %s", p.idx.Filename, err)
+		}
+		p.idx.outdated = false
+
+		if err := os.Remove(p.stale); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// Renames (and new directories) aren't durable until the
+		// directories holding them are synced.
+		syncParents(filepath.Dir(p.idx.Filename))
+	}
+
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("Could not sync index directory %s: %s", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// A pendingIndex is an index file that has been written to tmpfn, but not
+// yet renamed into place.
+type pendingIndex struct {
+	idx         *Index
+	tmpfn       string
+	stale       string // The same index in the other format, to be removed.
+	checkpoints []pendingCheckpoints
+}
+
+// pendingCheckpoints are the checkpoints of a data file, written to tmpfn to
+// be renamed to filename, or with an empty tmpfn, to be removed.
+type pendingCheckpoints struct {
+	tmpfn    string
+	filename string
+}
+
+// writeNew writes idx, and every sub index that has been read, to their
+// ".new" files, and adds them to pending with sub indexes ahead of their
+// parents.
+func (idx *Index) writeNew(pending *[]pendingIndex) error {
+	format, err := indexFormat(idx.Config)
+	if err != nil {
+		return err
+	}
+	idx.Filename = indexFilename(idx.Config, idx.subDir, format)
+
 	tmpfn := fmt.Sprintf("%s.new", idx.Filename)
 
 	idxPath, _ := filepath.Split(idx.Filename)
 	// Create the index directory if it doesn't exist.
-	os.MkdirAll(idxPath, 0777)
+	if err := os.MkdirAll(idxPath, 0777); err != nil {
+		return err
+	}
+
+	entries := make([]Entry, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		entries = append(entries, entry)
+
+		if entry.subIndex != nil {
+			if err := entry.subIndex.writeNew(pending); err != nil {
+				return err
+			}
+		}
+	}
 
 	outfile, err := os.Create(tmpfn)
 	if err != nil {
 		return err
 	}
-	defer outfile.Close()
 
-	csv_file := csv.NewWriter(outfile)
+	*pending = append(*pending, pendingIndex{
+		idx:   idx,
+		tmpfn: tmpfn,
+		stale: indexFilename(idx.Config, idx.subDir, otherFormat(format)),
+	})
 
-	for path, entry := range idx.entries {
-		Earliest_bytes, _ := tf_time.MarshalTime(entry.Period.Earliest)
-		Latest_bytes, _ := tf_time.MarshalTime(entry.Period.Latest)
-		Modified_bytes, _ := tf_time.MarshalTime(entry.Modified)
-
-		recs := []string{path,
-			string(Earliest_bytes),
-			string(Latest_bytes),
-			string(Modified_bytes)}
-		err := csv_file.Write(recs)
-		if err != nil {
-			return err
-		}
+	if format == FormatBinary {
+		err = writeBinary(outfile, entries)
+	} else {
+		err = writeCSV(outfile, entries, idx.Config.Type)
+	}
+	if err == nil {
+		err = outfile.Sync()
+	}
+	if cerr := outfile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Could not write index %s: %s", tmpfn, err)
+	}
 
-		if entry.subIndex != nil {
-			if err := entry.subIndex.WriteOut(); err != nil {
+	p := &(*pending)[len(*pending)-1]
+	for path, checkpoints := range idx.checkpoints {
+		filename, ok := checkpointsFilename(idx.Config, path)
+		if !ok {
+			continue
+		}
+		cp := pendingCheckpoints{filename: filename}
+		if entry, ok := idx.entries[path]; ok && checkpoints != nil {
+			if cp.tmpfn, err = writeCheckpoints(filename, entry, checkpoints); err != nil {
 				return err
 			}
 		}
+		p.checkpoints = append(p.checkpoints, cp)
+	}
+	return nil
+}
 
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
 	}
+	return err
+}
 
-	csv_file.Flush()
+// RemoveStale removes the ".new" index and checkpoints files of cfg left
+// behind by a timefind_indexer that didn't finish, and returns how many there
+// were. The caller must hold the indexer lock, or it might remove the files
+// of an indexer that's still running.
+func RemoveStale(cfg *config.Configuration) (int, error) {
+	removed := 0
+	err := filepath.Walk(cfg.IndexDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !isStale(cfg, path) {
+			return nil
+		}
 
-	// It's okay to rename while open, on Unix,
-	// since the file descriptor doesn't care about the filename
-	os.Rename(tmpfn, idx.Filename)
+		vlog("removing stale index file %s", path)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
 
-	return nil
+// isStale reports whether the file at path is a leftover ".new" index or
+// checkpoints file of cfg.
+func isStale(cfg *config.Configuration, path string) bool {
+	name := filepath.Base(path)
+	if isCheckpointsDir(cfg, filepath.Dir(path)) && strings.HasSuffix(name, ".new") {
+		return true
+	}
+	for _, format := range []string{FormatCSV, FormatBinary} {
+		if name == filepath.Base(indexFilename(cfg, "", format))+".new" {
+			return true
+		}
+	}
+	return false
 }
 
 // vim: noet:ts=4:sw=4:tw=80
@@ -0,0 +1,409 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"timefind/internal/fixture"
+	"timefind/processor"
+	tf_time "timefind/time"
+)
+
+func TestUnknownProcessor(t *testing.T) {
+	cfg := fixture.Source(t, "test", "fsdb_time_col_1",
+		map[string]string{"a.fsdb": "1436000000.5\tx\n1436000100\ty\n"})
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteOut(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An index built by a processor this program doesn't have can still be
+	// searched, but not updated.
+	cfg.Type = "widget"
+	idx, err = NewIndex(cfg)
+	if err != nil {
+		t.Fatalf("NewIndex: %s", err)
+	}
+	if entries := idx.FindLogs(idx.Period.Earliest, idx.Period.Latest); len(entries) != 1 {
+		t.Errorf("FindLogs found %d entries, expected 1", len(entries))
+	}
+	if err := idx.Update(); err == nil {
+		t.Errorf("Update with an unknown processor: expected an error")
+	}
+}
+
+func TestFind(t *testing.T) {
+	// Some short periods, some long ones, and one that covers everything.
+	rng := rand.New(rand.NewSource(1))
+	base := time.Unix(1436000000, 0).UTC()
+	idx := &Index{entries: map[string]Entry{}}
+	for i := 0; i < 500; i++ {
+		start := base.Add(time.Duration(rng.Intn(100000)) * time.Second)
+		length := time.Duration(rng.Intn(100)) * time.Second
+		if i%50 == 0 {
+			length = time.Duration(rng.Intn(50000)) * time.Second
+		}
+		if i == 250 {
+			start, length = base, 200000*time.Second
+		}
+		path := fmt.Sprintf("/data/%03d", i)
+		idx.entries[path] = Entry{Path: path,
+			Period: tf_time.Times{Earliest: start, Latest: start.Add(length)}}
+	}
+
+	for i := 0; i < 200; i++ {
+		earliest := base.Add(time.Duration(rng.Intn(110000)-5000) * time.Second)
+		window := tf_time.Times{Earliest: earliest,
+			Latest: earliest.Add(time.Duration(rng.Intn(1000)) * time.Second)}
+
+		for _, match := range []Match{MatchOverlaps, MatchWithin, MatchCovering} {
+			want := map[string]bool{}
+			for path, entry := range idx.entries {
+				p := entry.Period
+				if match == MatchOverlaps && p.Overlaps(window) ||
+					match == MatchWithin && p.Within(window) ||
+					match == MatchCovering && p.Covers(window) {
+					want[path] = true
+				}
+			}
+
+			got := idx.Find(window, match)
+			for j, entry := range got {
+				if !want[entry.Path] {
+					t.Errorf("Find(%v, %d) found %s: %v", window, match, entry.Path, entry.Period)
+				}
+				delete(want, entry.Path)
+				if j > 0 && entry.Period.Earliest.Before(got[j-1].Period.Earliest) {
+					t.Errorf("Find(%v, %d) out of order at %s", window, match, entry.Path)
+				}
+			}
+			for path := range want {
+				t.Errorf("Find(%v, %d) missed %s: %v", window, match, path, idx.entries[path].Period)
+			}
+		}
+	}
+}
+
+func TestUpdateSkipsBadExecFile(t *testing.T) {
+	cfg := fixture.Source(t, "test", "exec",
+		map[string]string{"good.log": "1436000000\n", "bad.log": "yesterday\n"})
+	cfg.Options = map[string]string{"command": "cat {}"}
+	good := filepath.Join(cfg.Paths[0], "good.log")
+	bad := filepath.Join(cfg.Paths[0], "bad.log")
+
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatalf("Update with a bad file: %s", err)
+	}
+	if n := idx.CorruptFiles(); n != 1 {
+		t.Errorf("%d corrupt files, expected 1", n)
+	}
+	if entries := idx.FindLogs(tf_time.MinTime, tf_time.MaxTime); len(entries) != 1 || entries[0].Path != good {
+		t.Errorf("found %v, expected just %s", entries, good)
+	}
+
+	// Once it's fixed, it's indexed on the next update.
+	fixture.Files(t, "", map[string]string{bad: "1436000100\n"})
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := idx.FindLogs(tf_time.MinTime, tf_time.MaxTime); len(entries) != 2 {
+		t.Errorf("found %v after fixing %s, expected both files", entries, bad)
+	}
+}
+
+func TestUpdateArchive(t *testing.T) {
+	cfg := fixture.Source(t, "test", "exec", nil)
+	cfg.Archives = true
+	cfg.Options = map[string]string{"command": "cat {}"}
+	archive := filepath.Join(cfg.Paths[0], "logs.tar")
+	truncated := filepath.Join(cfg.Paths[0], "truncated.tar")
+	fixture.Archive(t, archive, fixture.Member{Name: "bad.log", Data: "yesterday\n"},
+		fixture.Member{Name: "good.log", Data: "1436000000\n"})
+	fixture.Archive(t, truncated,
+		fixture.Member{Name: "a.log", Data: strings.Repeat("1436000000\n", 1000)})
+	data, err := os.ReadFile(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture.Files(t, "", map[string]string{truncated: string(data[:2000])})
+
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := func() []string {
+		paths := []string{}
+		for _, entry := range idx.FindLogs(tf_time.MinTime, tf_time.MaxTime) {
+			paths = append(paths, entry.Path)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	// Neither the corrupt member nor the truncated archive stops the update.
+	if err := idx.Update(); err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+	if n := idx.CorruptFiles(); n != 2 {
+		t.Errorf("%d corrupt files, expected 2", n)
+	}
+	good := archive + processor.ArchiveSep + "good.log"
+	bad := archive + processor.ArchiveSep + "bad.log"
+	if got := found(); !reflect.DeepEqual(got, []string{good}) {
+		t.Errorf("found %q, expected just %s", got, good)
+	}
+
+	// The corrupt member is retried, without the archive changing, and the
+	// good one is still there while it is.
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if n := idx.CorruptFiles(); n != 2 {
+		t.Errorf("%d corrupt files on the second update, expected 2 again", n)
+	}
+	if got := found(); !reflect.DeepEqual(got, []string{good}) {
+		t.Errorf("found %q on the second update, expected just %s", got, good)
+	}
+
+	// Once the archive is fixed, every member is indexed, and after that
+	// it's left alone.
+	fixture.Archive(t, archive, fixture.Member{Name: "bad.log", Data: "1436000100\n"},
+		fixture.Member{Name: "good.log", Data: "1436000000\n"})
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(archive, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if got := found(); !reflect.DeepEqual(got, []string{bad, good}) {
+		t.Errorf("found %q after fixing the archive, expected %s and %s", got, bad, good)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if n := idx.CorruptFiles(); n != 1 {
+		t.Errorf("%d corrupt files, expected just the truncated archive", n)
+	}
+}
+
+func TestLockIndexer(t *testing.T) {
+	cfg := fixture.Source(t, "test", "fsdb_time_col_1", nil)
+	first, err := LockIndexer(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second indexer fails fast without --wait.
+	if lock, err := LockIndexer(cfg, false); err != ErrLocked {
+		lock.Unlock()
+		t.Fatalf("second LockIndexer: got %v, expected ErrLocked", err)
+	}
+
+	// With --wait, it gets the lock once the first one lets go.
+	got := make(chan error)
+	go func() {
+		lock, err := LockIndexer(cfg, true)
+		lock.Unlock()
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		t.Fatalf("waiting LockIndexer returned %v while the lock was held", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Unlock()
+	if err := <-got; err != nil {
+		t.Errorf("waiting LockIndexer: %s", err)
+	}
+}
+
+func TestWriteOutSnapshot(t *testing.T) {
+	cfg := fixture.Source(t, "test", "fsdb_time_col_1", nil)
+	files := []string{
+		filepath.Join(cfg.Paths[0], "a.fsdb"),
+		filepath.Join(cfg.Paths[0], "sub", "b.fsdb"),
+	}
+	write := func(when int64, modified time.Time) {
+		for _, filename := range files {
+			fixture.Files(t, "", map[string]string{filename: fmt.Sprintf("%d\tx\n", when)})
+			if err := os.Chtimes(filename, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// earliest returns the earliest time of each file in the index on disk.
+	earliest := func() []int64 {
+		idx, err := NewIndex(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		times := []int64{}
+		for _, entry := range idx.FindLogs(tf_time.MinTime, tf_time.MaxTime) {
+			times = append(times, entry.Period.Earliest.Unix())
+		}
+		return times
+	}
+
+	write(1436000000, time.Now().Add(-time.Hour))
+	idx, err := NewIndex(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteOut(); err != nil {
+		t.Fatal(err)
+	}
+	old := []int64{1436000000, 1436000000}
+	if got := earliest(); !reflect.DeepEqual(got, old) {
+		t.Fatalf("indexed %v, expected %v", got, old)
+	}
+
+	write(1437000000, time.Now())
+	if err := idx.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	// While a reader holds the snapshot lock, WriteOut writes both of the
+	// new index files, but renames neither into place.
+	reader, err := LockSnapshot(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- idx.WriteOut() }()
+	for pending := 0; pending < 2; {
+		select {
+		case err := <-done:
+			t.Fatalf("WriteOut returned %v while a reader held the snapshot lock", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		pending = 0
+		filepath.Walk(cfg.IndexDir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && strings.HasSuffix(path, ".new") {
+				pending++
+			}
+			return nil
+		})
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("WriteOut returned %v while a reader held the snapshot lock", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := earliest(); !reflect.DeepEqual(got, old) {
+		t.Errorf("read %v while WriteOut waited, expected the old %v", got, old)
+	}
+
+	reader.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got, want := earliest(), []int64{1437000000, 1437000000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("read %v after WriteOut, expected the new %v", got, want)
+	}
+}
+
+func TestUpdateRemovedFile(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatBinary} {
+		cfg := fixture.Source(t, "test", "fsdb_time_col_1",
+			map[string]string{"kept.fsdb": "1436000000\tx\n", "removed.fsdb": "1436000000\tx\n"})
+		cfg.IndexFormat = format
+		kept := filepath.Join(cfg.Paths[0], "kept.fsdb")
+		removed := filepath.Join(cfg.Paths[0], "removed.fsdb")
+		// onDisk returns the paths in the index file itself.
+		onDisk := func() []string {
+			filename := indexFilename(cfg, "", format)
+			read := readCSV
+			if format == FormatBinary {
+				read = readBinary
+			}
+			entries, _, err := read(filename)
+			if err != nil {
+				t.Fatalf("%s: %s", format, err)
+			}
+			paths := []string{}
+			for _, entry := range entries {
+				paths = append(paths, entry.Path)
+			}
+			sort.Strings(paths)
+			return paths
+		}
+
+		for i := 0; i < 2; i++ {
+			if i == 1 {
+				if err := os.Remove(removed); err != nil {
+					t.Fatal(err)
+				}
+			}
+			idx, err := NewIndex(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := idx.Update(); err != nil {
+				t.Fatal(err)
+			}
+			if err := idx.WriteOut(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := onDisk(); !reflect.DeepEqual(got, []string{kept}) {
+			t.Errorf("%s: the index has %q after %s was removed, expected just %s",
+				format, got, removed, kept)
+		}
+		idx, err := NewIndex(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries := idx.FindLogs(tf_time.MinTime, tf_time.MaxTime); len(entries) != 1 {
+			t.Errorf("%s: found %v, expected just %s", format, entries, kept)
+		}
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	cfg := fixture.Source(t, "test", "fsdb_time_col_1", nil)
+	index := indexFilename(cfg, "", FormatCSV)
+	files := map[string]bool{
+		index:          false,
+		index + ".new": true,
+		indexFilename(cfg, "", FormatBinary) + ".new": true,
+		filepath.Join(cfg.IndexDir, "other.csv.new"):  false,
+	}
+	for filename := range files {
+		fixture.Files(t, "", map[string]string{filename: ""})
+	}
+
+	removed, err := RemoveStale(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d files, expected 2", removed)
+	}
+	for filename, stale := range files {
+		if _, err := os.Stat(filename); os.IsNotExist(err) != stale {
+			t.Errorf("%s: exists is %v, expected %v", filename, err == nil, !stale)
+		}
+	}
+}
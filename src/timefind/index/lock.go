@@ -0,0 +1,95 @@
+package index
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"timefind/config"
+)
+
+/*
+  Each index tree has two advisory (flock) locks, as files in its indexDir:
+
+    NAME.lock           held exclusively by a timefind_indexer for as long
+                        as it works on the tree, so that two of them never
+                        update it at once.
+    NAME.snapshot.lock  held shared by readers for as long as they read the
+                        tree, and exclusively by WriteOut while it renames
+                        a new generation of index files into place.
+
+  WriteOut writes every new index file before it takes the snapshot lock, so
+  readers are only held up for the renames, and they see either the whole
+  old tree or the whole new one.
+*/
+
+// ErrLocked is returned by LockIndexer when another process is already
+// indexing the same tree and we were asked not to wait.
+var ErrLocked = errors.New("index is locked by another timefind_indexer")
+
+// A Lock is a held index lock.
+type Lock struct {
+	f *os.File
+}
+
+func lockFilename(cfg *config.Configuration, suffix string) string {
+	return filepath.Join(cfg.IndexDir, cfg.Name+suffix)
+}
+
+// LockIndexer takes the indexer lock of cfg's index tree. If another
+// process holds it, LockIndexer waits for it to be released if wait is true,
+// and returns ErrLocked if not.
+func LockIndexer(cfg *config.Configuration, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(cfg.IndexDir, 0777); err != nil {
+		return nil, err
+	}
+	return lockFile(lockFilename(cfg, ".lock"), syscall.LOCK_EX, wait)
+}
+
+// LockSnapshot takes a shared lock on cfg's index tree, which keeps WriteOut
+// from replacing any of it until the lock is released. It fails if the lock
+// file can't be opened, e.g., because the index doesn't exist yet.
+func LockSnapshot(cfg *config.Configuration) (*Lock, error) {
+	return lockFile(lockFilename(cfg, ".snapshot.lock"), syscall.LOCK_SH, true)
+}
+
+// lockPublish takes the snapshot lock exclusively, waiting for readers to
+// finish.
+func lockPublish(cfg *config.Configuration) (*Lock, error) {
+	return lockFile(lockFilename(cfg, ".snapshot.lock"), syscall.LOCK_EX, true)
+}
+
+func lockFile(filename string, how int, wait bool) (*Lock, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil && how == syscall.LOCK_SH {
+		// Readers may not be able to write to the index directory, but
+		// flock works just as well on a read-only descriptor.
+		f, err = os.Open(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock. Unlocking a nil Lock does nothing.
+func (l *Lock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	// Closing the file releases the lock.
+	return l.f.Close()
+}
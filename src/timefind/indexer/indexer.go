@@ -16,11 +16,29 @@ import (
 // TODO should probably put these in a struct?
 var configPaths []string = []string{}
 var verbose bool = false
+var convertFormat string
+var migrate bool = false
+var wait bool = false
+var check bool = false
+var repair bool = false
+var force bool = false
 
 func main() {
 	getopt.ListVarLong(&configPaths, "config", 'c',
 		"REQUIRED: Path to configuration file (can be used multiple times)", "PATH")
 	getopt.BoolVarLong(&verbose, "verbose", 'v', "Verbose progress indicators and messages")
+	getopt.StringVarLong(&convertFormat, "convert", 'C',
+		"Rewrite existing indexes in FORMAT (csv or binary) without updating them", "FORMAT")
+	getopt.BoolVarLong(&migrate, "migrate", 'M',
+		"Upgrade existing indexes to the current index version without updating them")
+	getopt.BoolVarLong(&wait, "wait", 'w',
+		"Wait for another timefind_indexer using the same index to finish, instead of skipping it")
+	getopt.BoolVarLong(&force, "force", 'f',
+		"Reprocess every data file, even the ones that haven't changed")
+	getopt.BoolVarLong(&check, "check", 'k',
+		"Check existing indexes for inconsistencies without updating them")
+	getopt.BoolVarLong(&repair, "repair", 'r',
+		"With --check, also repair what can be repaired")
 	help := getopt.BoolLong("help", 'h', "Show this help message and exit")
 	getopt.SetParameters("")
 	getopt.Parse()
@@ -44,6 +62,9 @@ func main() {
 		log.Printf("setting GOMAXPROCS = NumCPU = %d\n", runtime.NumCPU())
 	}
 
+	// With --check, the kinds of problems found in every index.
+	status := 0
+
 	for _, configPath := range configPaths {
 
 		cfg, err := config.NewConfiguration(configPath)
@@ -52,22 +73,133 @@ func main() {
 			continue
 		}
 
-		idx, err := index.NewIndex(cfg)
-		if err != nil {
+		lock, err := index.LockIndexer(cfg, wait)
+		if err == index.ErrLocked {
+			log.Printf("%s: %s, skipping", cfg.Name, err)
+			continue
+		} else if err != nil {
 			log.Print(err)
 			return
 		}
 
-		if err := idx.Update(); err != nil {
-			log.Print(err)
-			return
+		if check {
+			kinds, err := checkIndex(cfg, repair)
+			lock.Unlock()
+			if err != nil {
+				log.Fatalf("%s: %s", cfg.Name, err)
+			}
+			status |= kinds
+			continue
+		}
+
+		if n, err := index.RemoveStale(cfg); err != nil {
+			lock.Unlock()
+			log.Fatal(err)
+		} else if n > 0 {
+			log.Printf("%s: removed %d index files left by an unfinished run", cfg.Name, n)
+		}
+
+		if err := indexSource(cfg); err != nil {
+			lock.Unlock()
+			log.Fatalf("%s: %s", cfg.Name, err)
 		}
+		lock.Unlock()
+	}
+
+	os.Exit(status)
+}
 
+// checkIndex reports every inconsistency in the index tree of cfg, repairs
+// them if asked to, and returns the kinds of problems it found.
+func checkIndex(cfg *config.Configuration, repair bool) (int, error) {
+	idx, err := index.NewIndex(cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	problems, err := idx.Check(repair)
+	if err != nil {
+		return 0, err
+	}
+
+	kinds := 0
+	for _, problem := range problems {
+		log.Printf("%s: %s", cfg.Name, problem)
+		kinds |= problem.Kind
+	}
+	log.Printf("%s: %d problems found", cfg.Name, len(problems))
+
+	if repair && len(problems) > 0 {
 		if err := idx.WriteOut(); err != nil {
-			log.Print(err)
-			return
+			return kinds, err
 		}
+		log.Printf("%s: index repaired", cfg.Name)
 	}
+
+	return kinds, nil
+}
+
+// indexSource does whatever was asked of one source's index.
+func indexSource(cfg *config.Configuration) error {
+	switch {
+	case convertFormat != "":
+		return convert(cfg, convertFormat)
+	case migrate:
+		return migrateIndex(cfg)
+	}
+
+	idx, err := index.NewIndex(cfg)
+	if err != nil {
+		return err
+	}
+
+	idx.Force = force
+	if err := idx.Update(); err != nil {
+		return err
+	}
+
+	if err := idx.WriteOut(); err != nil {
+		return err
+	}
+
+	if n := idx.CorruptFiles(); n > 0 {
+		log.Printf("%s: %d corrupt data files were not indexed", cfg.Name, n)
+	}
+	return nil
+}
+
+// convert rewrites the whole index tree of cfg in format. NewIndex reads
+// either format, so this only reads and writes the tree.
+func convert(cfg *config.Configuration, format string) error {
+	idx, err := index.NewIndex(cfg)
+	if err != nil {
+		return err
+	}
+	idx.LoadAll()
+
+	log.Printf("%s: converting index to %s", cfg.Name, format)
+	cfg.IndexFormat = format
+	return idx.WriteOut()
+}
+
+// migrateIndex rewrites every index file of cfg that is from an older
+// version of the index format. NewIndex reads all of them, and WriteOut
+// always writes the newest.
+func migrateIndex(cfg *config.Configuration) error {
+	idx, err := index.NewIndex(cfg)
+	if err != nil {
+		return err
+	}
+	idx.LoadAll()
+
+	n := idx.Outdated()
+	if n == 0 {
+		log.Printf("%s: index is up to date", cfg.Name)
+		return nil
+	}
+
+	log.Printf("%s: upgrading %d index files", cfg.Name, n)
+	return idx.WriteOut()
 }
 
 // vim: noet:ts=4:sw=4:tw=80
@@ -0,0 +1,100 @@
+// Package fixture writes the data files, archives and source configurations
+// that the tests of the other packages run against.
+package fixture
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"timefind/config"
+)
+
+// Source returns the configuration of a source called name, of type typ,
+// with its data and index directories under a new temporary directory, and
+// files (see Files) written to its data directory.
+func Source(t testing.TB, name string, typ string, files map[string]string) *config.Configuration {
+	dir := t.TempDir()
+	cfg := &config.Configuration{
+		Name:     name,
+		IndexDir: filepath.Join(dir, "index"),
+		Paths:    []string{filepath.Join(dir, "data")},
+		Include:  []string{"*"},
+		Type:     typ,
+	}
+	if err := os.MkdirAll(cfg.Paths[0], 0777); err != nil {
+		t.Fatal(err)
+	}
+	Files(t, cfg.Paths[0], files)
+	return cfg
+}
+
+// Files writes each of files, a path relative to dir (or an absolute one)
+// and its contents, making the directories it needs.
+func Files(t testing.TB, dir string, files map[string]string) {
+	for name, data := range files {
+		filename := name
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, name)
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// A Member is a file to put in an archive. A Name ending in "/" is a
+// directory.
+type Member struct {
+	Name string
+	Data string
+}
+
+// Archive writes members, in order, to the archive filename: a zip file, a
+// gzipped tar file or a tar file, depending on its name.
+func Archive(t testing.TB, filename string, members ...Member) {
+	var buf bytes.Buffer
+	if strings.HasSuffix(filename, ".zip") {
+		zw := zip.NewWriter(&buf)
+		for _, m := range members {
+			w, err := zw.Create(m.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(m.Data))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		var zw *gzip.Writer
+		tw := tar.NewWriter(&buf)
+		if strings.HasSuffix(filename, ".gz") || strings.HasSuffix(filename, ".tgz") {
+			zw = gzip.NewWriter(&buf)
+			tw = tar.NewWriter(zw)
+		}
+		for _, m := range members {
+			hdr := &tar.Header{Name: m.Name, Mode: 0666, Size: int64(len(m.Data))}
+			if strings.HasSuffix(m.Name, "/") {
+				hdr = &tar.Header{Name: m.Name, Typeflag: tar.TypeDir, Mode: 0777}
+			}
+			tw.WriteHeader(hdr)
+			tw.Write([]byte(m.Data))
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if zw != nil {
+			zw.Close()
+		}
+	}
+	Files(t, "", map[string]string{filename: buf.String()})
+}
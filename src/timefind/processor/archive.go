@@ -0,0 +1,320 @@
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"xi2.org/x/xz"
+)
+
+// Archive members are indexed as "ARCHIVE!MEMBER", e.g.,
+// /data/logs/bundle.tar.gz!var/log/messages
+const ArchiveSep = "!"
+
+var archiveSuffixes = []string{
+	".tar",
+	".tar.gz",
+	".tgz",
+	".tar.bz2",
+	".tbz2",
+	".tar.xz",
+	".txz",
+	".zip",
+}
+
+// IsArchive reports whether filename looks like a tar or zip archive.
+func IsArchive(filename string) bool {
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(filename, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitArchivePath splits an index path of the form "ARCHIVE!MEMBER". ok is
+// false if path doesn't refer to an archive member.
+func SplitArchivePath(path string) (archive, member string, ok bool) {
+	for i := 0; i < len(path); i++ {
+		j := strings.Index(path[i:], ArchiveSep)
+		if j < 0 {
+			break
+		}
+		i += j
+		if IsArchive(path[:i]) {
+			return path[:i], path[i+len(ArchiveSep):], true
+		}
+	}
+	return path, "", false
+}
+
+// WalkArchive calls fn with the name, size and contents of every regular file
+// in the tar or zip archive filename, in archive order.
+func WalkArchive(filename string, fn func(member string, size int64, r io.Reader) error) error {
+	if strings.HasSuffix(filename, ".zip") {
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		for _, zf := range zr.File {
+			if !zf.Mode().IsRegular() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(zf.Name, int64(zf.UncompressedSize64), rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := openTar(f)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if err := fn(hdr.Name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// ProcessArchive runs p over every member of the archive filename and passes
+// each result, and the member's size within the archive, on to fn. Members are
+// decompressed according to their own names. Checkpoints aren't taken, since
+// there's no seeking within an archive. If the archive itself can't be read
+// (e.g., it's truncated), that error is returned, without calling fn for the
+// member it was found in.
+func ProcessArchive(filename string, p Processor, opts Options, extras Extras,
+	fn func(member string, size int64, res Result, err error) error) error {
+
+	extras.Checkpoints = 0
+
+	return WalkArchive(filename, func(member string, size int64, r io.Reader) error {
+		path := filename + ArchiveSep + member
+		ar := &archiveReader{r: r}
+
+		reader, err := Decompress(ar, member)
+		if ar.err != nil {
+			return ar.err
+		} else if err != nil {
+			return fn(member, size, Result{}, err)
+		}
+
+		res := extras.newResult()
+		err = p.Process(reader, path, opts, &res)
+		if ar.err != nil {
+			// Whatever the processor made of it.
+			return ar.err
+		} else if err != nil {
+			return fn(member, size, Result{}, err)
+		}
+		return fn(member, size, res, nil)
+	})
+}
+
+// archiveReader notes the first error reading a member from its archive, to
+// tell a damaged archive apart from a bad member.
+type archiveReader struct {
+	r   io.Reader
+	err error
+}
+
+func (ar *archiveReader) Read(p []byte) (int, error) {
+	n, err := ar.r.Read(p)
+	if err != nil && err != io.EOF && ar.err == nil {
+		ar.err = err
+	}
+	return n, err
+}
+
+// OpenMember opens the archive member named by path ("ARCHIVE!MEMBER") for
+// reading, as it is in the archive; see Decompress.
+func OpenMember(path string) (io.ReadCloser, error) {
+	archive, member, ok := SplitArchivePath(path)
+	if !ok {
+		return nil, fmt.Errorf("not an archive member: %s", path)
+	}
+	notFound := fmt.Errorf("%s: no member named %q", archive, member)
+
+	if strings.HasSuffix(archive, ".zip") {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		for _, zf := range zr.File {
+			if zf.Name != member || !zf.Mode().IsRegular() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return readCloser{rc, multiCloser{rc, zr}}, nil
+		}
+		zr.Close()
+		return nil, notFound
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := openTar(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			err = notFound
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if hdr.Name == member && hdr.FileInfo().Mode().IsRegular() {
+			return readCloser{tr, f}, nil
+		}
+	}
+}
+
+type multiCloser []io.Closer
+
+func (c multiCloser) Close() error {
+	var err error
+	for _, closer := range c {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ExtractMember copies the archive member named by path ("ARCHIVE!MEMBER") to
+// dir, as ExtractMembers does, and returns the path of the new file.
+func ExtractMember(path string, dir string) (string, error) {
+	archive, member, ok := SplitArchivePath(path)
+	if !ok {
+		return "", fmt.Errorf("not an archive member: %s", path)
+	}
+
+	dests, err := ExtractMembers(archive, []string{member}, dir)
+	if err != nil {
+		return "", err
+	}
+	dest, ok := dests[member]
+	if !ok {
+		return "", fmt.Errorf("%s: no member named %q", archive, member)
+	}
+	return dest, nil
+}
+
+// MemberPath returns where ExtractMembers puts member of archive in dir: under
+// the archive's base name, and never outside it, whatever the member's name.
+func MemberPath(archive string, member string, dir string) string {
+	return filepath.Join(dir, filepath.Base(archive), filepath.Clean("/"+member))
+}
+
+// ExtractMembers copies members of archive to dir (see MemberPath), in one
+// pass over the archive, and returns the paths of the new files by member.
+// Members that aren't in the archive are left out. Each is written under a
+// temporary name and renamed into place once complete, and given the
+// archive's modification time, so a file at its path is always a whole member
+// of that version of the archive.
+func ExtractMembers(archive string, members []string, dir string) (map[string]string, error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, member := range members {
+		wanted[member] = true
+	}
+	dests := map[string]string{}
+
+	err = WalkArchive(archive, func(name string, size int64, r io.Reader) error {
+		if !wanted[name] {
+			return nil
+		}
+		delete(wanted, name)
+
+		dest := MemberPath(archive, name, dir)
+		if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+			return err
+		}
+		tmp := dest + ".partial"
+		if err := copyToFile(tmp, r); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, dest); err != nil {
+			return err
+		}
+		dests[name] = dest
+		if len(wanted) == 0 {
+			return errExtracted
+		}
+		return nil
+	})
+	if err == errExtracted {
+		err = nil
+	}
+	return dests, err
+}
+
+// errExtracted stops ExtractMembers walking the archive once it has every
+// member it wants.
+var errExtracted = errors.New("all members extracted")
+
+func openTar(f *os.File) (io.Reader, error) {
+	filename := f.Name()
+	switch {
+	case strings.HasSuffix(filename, ".gz"), strings.HasSuffix(filename, ".tgz"):
+		return gzip.NewReader(f)
+	case strings.HasSuffix(filename, ".bz2"), strings.HasSuffix(filename, ".tbz2"):
+		return bzip2.NewReader(f), nil
+	case strings.HasSuffix(filename, ".xz"), strings.HasSuffix(filename, ".txz"):
+		return xz.NewReader(f, 0)
+	}
+	return f, nil
+}
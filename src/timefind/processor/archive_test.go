@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"timefind/internal/fixture"
+)
+
+func TestSplitArchivePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		archive string
+		member  string
+		ok      bool
+	}{
+		{"/data/a.tar.gz!logs/messages", "/data/a.tar.gz", "logs/messages", true},
+		{"/data/a.zip!b.log", "/data/a.zip", "b.log", true},
+		{"/data/a.tar!x!y", "/data/a.tar", "x!y", true},
+		// A "!" that doesn't follow an archive name is part of the path.
+		{"/data/wow!/a.tgz!m", "/data/wow!/a.tgz", "m", true},
+		{"/data/wow!.log", "/data/wow!.log", "", false},
+		{"/data/a.tar.gz", "/data/a.tar.gz", "", false},
+		{"/data/a.gz!m", "/data/a.gz!m", "", false},
+	}
+	for _, test := range tests {
+		archive, member, ok := SplitArchivePath(test.path)
+		if archive != test.archive || member != test.member || ok != test.ok {
+			t.Errorf("SplitArchivePath(%q) = %q, %q, %v, expected %q, %q, %v", test.path,
+				archive, member, ok, test.archive, test.member, test.ok)
+		}
+	}
+}
+
+func TestWalkArchive(t *testing.T) {
+	dir := t.TempDir()
+	members := []fixture.Member{
+		{Name: "b.log", Data: "second\n"},
+		{Name: "dir/a.log", Data: "first\n"},
+		{Name: "empty", Data: ""},
+	}
+
+	for _, name := range []string{"a.tar.gz", "a.zip"} {
+		// Directories aren't walked.
+		filename := filepath.Join(dir, name)
+		fixture.Archive(t, filename, append([]fixture.Member{{Name: "dir/"}}, members...)...)
+
+		got := []fixture.Member{}
+		err := WalkArchive(filename, func(member string, size int64, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			if int64(len(data)) != size {
+				t.Errorf("%s: %s is %d bytes, but the archive says %d", name, member, len(data), size)
+			}
+			got = append(got, fixture.Member{Name: member, Data: string(data)})
+			return err
+		})
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if !reflect.DeepEqual(got, members) {
+			t.Errorf("%s: got %q, expected %q", name, got, members)
+		}
+
+		// Members can be opened on their own too.
+		for _, m := range members {
+			rc, err := OpenMember(filename + ArchiveSep + m.Name)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != m.Data {
+				t.Errorf("%s: OpenMember(%s) read %q, expected %q", name, m.Name, data, m.Data)
+			}
+		}
+		if _, err := OpenMember(filename + ArchiveSep + "missing"); err == nil {
+			t.Errorf("%s: opened a missing member", name)
+		}
+	}
+
+	// A truncated archive is an error, not a short list of members.
+	data, err := os.ReadFile(filepath.Join(dir, "a.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.tar.gz")
+	fixture.Files(t, dir, map[string]string{truncated: string(data[:len(data)/2])})
+	err = WalkArchive(truncated, func(member string, size int64, r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+	if err == nil {
+		t.Error("walked a truncated archive without an error")
+	}
+}
+
+func TestExtractMember(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "a.tar.gz")
+	fixture.Archive(t, archive,
+		fixture.Member{Name: "logs/a.log", Data: "a\n"},
+		fixture.Member{Name: "../../escape.log", Data: "up\n"},
+		fixture.Member{Name: "/abs/b.log", Data: "b\n"})
+
+	out := filepath.Join(dir, "out")
+	tests := []struct {
+		member string
+		want   string // Relative to out
+	}{
+		{"logs/a.log", "a.tar.gz/logs/a.log"},
+		// Members can't be written outside the archive's directory.
+		{"../../escape.log", "a.tar.gz/escape.log"},
+		{"/abs/b.log", "a.tar.gz/abs/b.log"},
+	}
+	for _, test := range tests {
+		got, err := ExtractMember(archive+ArchiveSep+test.member, out)
+		if err != nil {
+			t.Errorf("%s: %s", test.member, err)
+			continue
+		}
+		if want := filepath.Join(out, test.want); got != want {
+			t.Errorf("%s: extracted to %s, expected %s", test.member, got, want)
+		}
+		if !strings.HasPrefix(got, out+string(filepath.Separator)) {
+			t.Errorf("%s: extracted outside %s: %s", test.member, out, got)
+		}
+	}
+
+	if _, err := ExtractMember(archive+ArchiveSep+"missing", out); err == nil {
+		t.Error("extracted a missing member")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.log")); err == nil {
+		t.Error("a member escaped the output directory")
+	}
+}
@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	tf_time "timefind/time"
+)
+
+// Cat writes the decompressed contents of files to w as one stream in time
+// order.
+//
+// If p is an Extractor, the records of all the files are merged by time under
+// a single header (see Extract), so files whose periods overlap come out
+// interleaved. Otherwise the files are copied whole, one after another, which
+// only works if no two of them overlap. Times without a zone in the files are
+// in loc.
+func Cat(w io.Writer, p Processor, opts Options, loc *time.Location, files []ExtractFile) error {
+	if x, ok := p.(Extractor); ok {
+		all := make([]ExtractFile, len(files))
+		for i, file := range files {
+			all[i] = file
+			all[i].Offset = 0
+		}
+		return Extract(w, x, opts, loc, all, tf_time.MinTime, tf_time.MaxTime)
+	}
+
+	files = append([]ExtractFile{}, files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Earliest.Before(files[j].Earliest)
+	})
+	for i := 1; i < len(files); i++ {
+		if !files[i].Earliest.After(files[i-1].Latest) {
+			return fmt.Errorf("%s and %s overlap, and records of type %s can't be merged (only those timefind extract works with can)",
+				files[i-1].Filename, files[i].Filename, p.Name())
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, file := range files {
+		if err := copyFile(bw, file.Filename); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// copyFile writes the decompressed contents of filename, which may be an
+// archive member, to w.
+func copyFile(w io.Writer, filename string) error {
+	reader, closer, err := openData(filename)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	_, err = io.Copy(w, reader)
+	return err
+}
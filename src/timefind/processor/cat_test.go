@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"timefind/internal/fixture"
+)
+
+// catProcessor is a processor that isn't a builtin, so Cat can't merge it.
+type catProcessor struct{}
+
+func (catProcessor) Name() string      { return "cat_test" }
+func (catProcessor) Options() []string { return nil }
+func (catProcessor) Process(reader io.Reader, filename string, opts Options, res *Result) error {
+	return nil
+}
+
+// bluecoatLog returns a bluecoat log with one record at when.
+func bluecoatLog(when string) string {
+	return "#Software: SGOS\n#Version: 1.0\n#Start-Date: " + when + "\n#Date: " + when +
+		"\n#Fields: date time c-ip\n#Remark:\n" + when + " 10.0.0.1\n"
+}
+
+func TestCat(t *testing.T) {
+	dir := t.TempDir()
+	lookup := func(name string) Processor {
+		p, ok := Lookup(name)
+		if !ok {
+			t.Fatalf("no processor %s", name)
+		}
+		return p
+	}
+
+	// An archive with an FSDB member.
+	fixture.Archive(t, filepath.Join(dir, "a.tar.gz"),
+		fixture.Member{Name: "logs/m.fsdb", Data: "#fsdb -F t time name\n101\tm1\n104\tm2\n"})
+	archived := ExtractFile{Filename: filepath.Join(dir, "a.tar.gz") + ArchiveSep + "logs/m.fsdb",
+		Earliest: time.Unix(101, 0), Latest: time.Unix(104, 0)}
+
+	tests := []struct {
+		name  string
+		p     Processor
+		files []ExtractFile
+		want  string
+		err   string // A substring of the expected error, if any
+	}{
+		{"extractor", lookup("fsdb_time_col_1"),
+			writeTestFiles(t, dir, []string{"a.fsdb", "b.fsdb"},
+				[][]byte{[]byte("#fsdb -F t time name\n100\ta1\n103\ta2\n"),
+					[]byte("#fsdb -F t time name\n102\tb1\n")},
+				[][2]int64{{100, 103}, {102, 102}}),
+			"#fsdb -F t time name\n100\ta1\n102\tb1\n103\ta2\n", ""},
+		{"archive member", lookup("fsdb_time_col_1"),
+			append(writeTestFiles(t, dir, []string{"c.fsdb"},
+				[][]byte{[]byte("#fsdb -F t time name\n100\tc1\n103\tc2\n")},
+				[][2]int64{{100, 103}}), archived),
+			"#fsdb -F t time name\n100\tc1\n101\tm1\n103\tc2\n104\tm2\n", ""},
+		// Lines without a time stay with the line before them.
+		{"lines", lookup("cpp"),
+			writeTestFiles(t, dir, []string{"a.cpp", "b.cpp"},
+				[][]byte{[]byte("100.5,a1\n103,a2\nmore a2\n"), []byte("101,b1\n104,b2\n")},
+				[][2]int64{{100, 103}, {101, 104}}),
+			"100.5,a1\n101,b1\n103,a2\nmore a2\n104,b2\n", ""},
+		{"mrt", lookup("mrt"),
+			writeTestFiles(t, dir, []string{"a.mrt", "b.mrt"},
+				[][]byte{join(mrtRecord(100, 16, 0, 4), mrtRecord(103, 16, 0, 4)),
+					join(mrtRecord(101, mrtTypeBGP4MP_ET, 5, 8))},
+				[][2]int64{{100, 103}, {101, 101}}),
+			string(join(mrtRecord(100, 16, 0, 4), mrtRecord(101, mrtTypeBGP4MP_ET, 5, 8),
+				mrtRecord(103, 16, 0, 4))), ""},
+		{"no records", lookup("fsdb_time_col_1"),
+			writeTestFiles(t, dir, []string{"d.fsdb", "e.fsdb"},
+				[][]byte{[]byte("#fsdb -F t time name\n100\td1\n"),
+					[]byte("#fsdb -F t time name\n# nothing\n")},
+				[][2]int64{{100, 100}, {100, 101}}),
+			"", "no records"},
+		// Other processors' files are only copied whole. bluecoat needs
+		// the lines before a record to find its time, so isn't merged.
+		{"bluecoat", lookup("bluecoat"),
+			writeTestFiles(t, dir, []string{"a.bluecoat", "b.bluecoat"},
+				[][]byte{[]byte(bluecoatLog("2015-07-01 00:00:00")), []byte(bluecoatLog("2015-07-01 02:00:00"))},
+				[][2]int64{{1435708800, 1435708800}, {1435716000, 1435716000}}),
+			bluecoatLog("2015-07-01 00:00:00") + bluecoatLog("2015-07-01 02:00:00"), ""},
+		{"bluecoat overlap", lookup("bluecoat"),
+			writeTestFiles(t, dir, []string{"c.bluecoat", "d.bluecoat"},
+				[][]byte{[]byte(bluecoatLog("2015-07-01 00:00:00")), []byte(bluecoatLog("2015-07-01 00:00:00"))},
+				[][2]int64{{1435708800, 1435708800}, {1435708800, 1435708800}}),
+			"", "can't be merged"},
+		{"custom", catProcessor{},
+			writeTestFiles(t, dir, []string{"b.custom", "a.custom"},
+				[][]byte{[]byte("second\n"), []byte("first\n")},
+				[][2]int64{{200, 300}, {100, 199}}),
+			"first\nsecond\n", ""},
+		{"custom overlap", catProcessor{},
+			writeTestFiles(t, dir, []string{"a.custom", "b.custom"},
+				[][]byte{[]byte("first\n"), []byte("second\n")},
+				[][2]int64{{100, 200}, {200, 300}}),
+			"", "overlap"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err := Cat(&buf, test.p, nil, time.UTC, test.files)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if buf.String() != test.want {
+			t.Errorf("%s: got\n%q\nexpected\n%q", test.name, buf.String(), test.want)
+		}
+	}
+}
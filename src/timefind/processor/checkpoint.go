@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Checkpoint marks a place in a data file's decompressed contents that a
+// reader can start at. Every record before Offset has a time no later than
+// Before, so a reader looking for records at or after some later time can
+// skip everything before Offset.
+type Checkpoint struct {
+	Offset int64
+	Before time.Time
+}
+
+// Checkpoints collects a Checkpoint about every Interval bytes of a data file.
+type Checkpoints struct {
+	Interval int64
+	List     []Checkpoint
+}
+
+// NewCheckpoints returns an empty Checkpoints for interval.
+func NewCheckpoints(interval int64) *Checkpoints {
+	return &Checkpoints{Interval: interval}
+}
+
+// AddAt records a parsed time, like Add, for a record that starts at offset
+// in the decompressed data. Processors that know where their records start
+// call AddAt instead of Add, so that checkpoints can be taken.
+func (res *Result) AddAt(t time.Time, offset int64) {
+	if cp := res.Checkpoints; cp != nil && res.Parsed > 0 {
+		last := int64(0)
+		if len(cp.List) > 0 {
+			last = cp.List[len(cp.List)-1].Offset
+		}
+		if offset-last >= cp.Interval {
+			cp.List = append(cp.List, Checkpoint{Offset: offset, Before: res.Period.Latest})
+		}
+	}
+	res.Add(t)
+}
+
+// SeekOffset returns the offset of the last checkpoint before which every
+// record is earlier than t, or 0 if there isn't one.
+func SeekOffset(checkpoints []Checkpoint, t time.Time) int64 {
+	// Before only grows from one checkpoint to the next.
+	i := sort.Search(len(checkpoints), func(i int) bool {
+		return !checkpoints[i].Before.Before(t)
+	})
+	if i == 0 {
+		return 0
+	}
+	return checkpoints[i-1].Offset
+}
+
+// A lineScanner is a bufio.Scanner over lines that also knows the offset at
+// which the current line starts.
+type lineScanner struct {
+	*bufio.Scanner
+	start int64 // Offset of the current line
+	next  int64 // Offset of whatever follows it
+}
+
+func newLineScanner(reader io.Reader) *lineScanner {
+	s := &lineScanner{Scanner: bufio.NewScanner(reader)}
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			s.start = s.next
+		}
+		s.next += int64(advance)
+		return advance, token, err
+	})
+	return s
+}
+
+// Offset returns the offset of the line last returned by Scan.
+func (s *lineScanner) Offset() int64 {
+	return s.start
+}
+
+// OpenAt opens and decompresses filename, and skips to offset in the
+// decompressed data. Plain files are seeked in, and xz files are decompressed
+// from the block that offset falls in (see openXZAt); other compressed ones
+// still have to be decompressed up to offset, but their records aren't
+// parsed.
+func OpenAt(filename string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(filename, ".xz") && offset > 0 {
+		reader, ok, err := openXZAt(f, offset)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			return readCloser{reader, f}, nil
+		}
+	}
+
+	reader, err := OpenFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if br, ok := reader.(*bufio.Reader); ok {
+		// Not compressed (see Decompress), so seek straight there.
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		br.Reset(f)
+	} else if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return readCloser{reader, f}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
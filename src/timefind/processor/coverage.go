@@ -0,0 +1,127 @@
+package processor
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	tf_time "timefind/time"
+)
+
+// A Coverage records which parts of its period a data file has records in,
+// as the set of fixed-size time slots (e.g., minutes) that at least one
+// record fell into. Slots are counted in nanoseconds, so a record before 1678
+// or after 2262 makes the coverage unknown, as if it hadn't been recorded.
+type Coverage struct {
+	Granularity time.Duration
+	slots       map[int64]bool
+	unknown     bool // A record didn't fit in a slot
+}
+
+var (
+	minCoverageTime = time.Unix(0, math.MinInt64)
+	maxCoverageTime = time.Unix(0, math.MaxInt64)
+)
+
+// NewCoverage returns an empty Coverage with slots of granularity.
+func NewCoverage(granularity time.Duration) *Coverage {
+	return &Coverage{Granularity: granularity, slots: map[int64]bool{}}
+}
+
+func (c *Coverage) add(t time.Time) {
+	g := int64(c.Granularity)
+	if t.Before(minCoverageTime) || t.After(maxCoverageTime) {
+		c.unknown = true
+		return
+	}
+	// The bounds of the first and last slots wouldn't fit either.
+	slot := floorDiv(t.UnixNano(), g)
+	if slot <= math.MinInt64/g || slot >= math.MaxInt64/g {
+		c.unknown = true
+		return
+	}
+	c.slots[slot] = true
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// Intervals returns the covered slots as a sorted list of at most max
+// intervals, clipped to period. Adjacent slots are joined, and if there are
+// still too many intervals, the ones with the smallest gaps between them are
+// joined too, so the result always covers every record. It returns nil if
+// the coverage is unknown.
+func (c *Coverage) Intervals(period tf_time.Times, max int) []tf_time.Times {
+	if c == nil || c.unknown || len(c.slots) == 0 || max < 1 {
+		return nil
+	}
+
+	slots := make([]int64, 0, len(c.slots))
+	for slot := range c.slots {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i] < slots[j] })
+
+	g := int64(c.Granularity)
+	bounds := func(first, last int64) tf_time.Times {
+		return tf_time.Times{
+			Earliest: time.Unix(0, first*g).UTC(),
+			Latest:   time.Unix(0, (last+1)*g-1).UTC(),
+		}
+	}
+
+	// Runs of consecutive slots, as [first, last] pairs.
+	runs := [][2]int64{{slots[0], slots[0]}}
+	for _, slot := range slots[1:] {
+		if last := &runs[len(runs)-1][1]; slot == *last+1 {
+			*last = slot
+		} else {
+			runs = append(runs, [2]int64{slot, slot})
+		}
+	}
+
+	if len(runs) > max {
+		// Close the len(runs)-max smallest gaps.
+		gaps := make([]int, len(runs)-1)
+		for i := range gaps {
+			gaps[i] = i
+		}
+		sort.SliceStable(gaps, func(i, j int) bool {
+			return runs[gaps[i]+1][0]-runs[gaps[i]][1] < runs[gaps[j]+1][0]-runs[gaps[j]][1]
+		})
+		closed := make([]bool, len(runs)-1)
+		for _, gap := range gaps[:len(runs)-max] {
+			closed[gap] = true
+		}
+
+		merged := [][2]int64{runs[0]}
+		for i, run := range runs[1:] {
+			if closed[i] {
+				merged[len(merged)-1][1] = run[1]
+			} else {
+				merged = append(merged, run)
+			}
+		}
+		runs = merged
+	}
+
+	intervals := make([]tf_time.Times, len(runs))
+	for i, run := range runs {
+		intervals[i] = bounds(run[0], run[1])
+	}
+
+	// The first and last slots can stick out past the records in them.
+	if first := &intervals[0]; first.Earliest.Before(period.Earliest) {
+		first.Earliest = period.Earliest
+	}
+	if last := &intervals[len(intervals)-1]; last.Latest.After(period.Latest) {
+		last.Latest = period.Latest
+	}
+
+	return intervals
+}
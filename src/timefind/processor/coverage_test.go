@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tf_time "timefind/time"
+)
+
+func TestCoverage(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0).UTC() }
+	interval := func(earliest, latest int64) tf_time.Times {
+		return tf_time.Times{Earliest: at(earliest), Latest: at(latest)}
+	}
+
+	tests := []struct {
+		name  string
+		times []time.Time
+		max   int
+		want  []tf_time.Times
+	}{
+		{"adjacent slots are joined", []time.Time{at(0), at(59), at(60), at(150)}, 10,
+			[]tf_time.Times{interval(0, 150)}},
+		// Only the first and last slots are clipped to the records.
+		{"gaps are kept", []time.Time{at(0), at(300), at(330)}, 10,
+			[]tf_time.Times{{Earliest: at(0), Latest: at(60).Add(-1)}, interval(300, 330)}},
+		{"the smallest gaps are closed first", []time.Time{at(0), at(300), at(420), at(1000)}, 2,
+			[]tf_time.Times{{Earliest: at(0), Latest: at(480).Add(-1)}, interval(960, 1000)}},
+		{"before 1970", []time.Time{at(-90), at(-30)}, 10,
+			[]tf_time.Times{interval(-90, -30)}},
+		// Times that don't fit in nanoseconds can't be put in a slot.
+		{"year 2", []time.Time{time.Date(2, 1, 1, 0, 0, 0, 0, time.UTC), at(0)}, 10, nil},
+		{"year 9999", []time.Time{at(0), time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)}, 10, nil},
+		{"the last slot", []time.Time{time.Unix(0, 1<<63-1)}, 10, nil},
+	}
+
+	for _, test := range tests {
+		res := Result{Coverage: NewCoverage(time.Minute)}
+		for _, t := range test.times {
+			res.Add(t)
+		}
+		got := res.Coverage.Intervals(res.Period, test.max)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	tf_time "timefind/time"
+)
+
+// The "exec" processor hands each data file to an external command and reads
+// the times it prints. Options:
+//
+//	command  the command to run, e.g. "tshark -r {} -T fields -e frame.time_epoch".
+//	         "{}" is replaced with the path of the data file. Arguments may be
+//	         quoted with '' or "", but the command isn't run by a shell.
+//	input    "path" (default) to only pass the path, or "stdin" to also feed the
+//	         decompressed contents of the file to the command's standard input.
+//	timeout  how long the command may run for, e.g. "90s" (default 10m).
+//
+// Every line of output holds one timestamp, or an "earliest latest" pair, in
+// any format tf_time.UnmarshalTime accepts. A non-zero exit status, a timeout
+// (which kills the command and anything it started), or a line that's too
+// long (over 1 MiB) or unparsable is a CorruptFileError, so that the file is
+// skipped, and tried again on the next update, rather than stopping the
+// whole update. Bad options, or a command that can't be started at all, are
+// plain errors.
+type execProcessor struct{}
+
+const defaultExecTimeout = 10 * time.Minute
+
+// How much of the command's standard error to include in error messages.
+const maxExecStderr = 4096
+
+// The longest line of output read from the command.
+const maxExecLine = 1024 * 1024
+
+func (execProcessor) Name() string { return "exec" }
+
+func (execProcessor) Options() []string {
+	return []string{"command", "input", "timeout"}
+}
+
+func (execProcessor) Process(reader io.Reader, filename string, opts Options, res *Result) error {
+	args, err := SplitCommand(opts["command"])
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("exec: no \"command\" option given")
+	}
+
+	timeout := defaultExecTimeout
+	if opts["timeout"] != "" {
+		if timeout, err = time.ParseDuration(opts["timeout"]); err != nil {
+			return fmt.Errorf("exec: bad timeout: %s", err)
+		}
+	}
+
+	path := filename
+	if _, _, ok := SplitArchivePath(filename); ok && opts["input"] != "stdin" {
+		// Archive members only exist inside the archive, so give the
+		// command a temporary copy to look at.
+		tmpdir, err := os.MkdirTemp("", "timefind")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpdir)
+
+		path = filepath.Join(tmpdir, filepath.Base(filename))
+		if err := copyToFile(path, reader); err != nil {
+			return err
+		}
+	}
+
+	for i := range args {
+		args[i] = strings.Replace(args[i], "{}", path, -1)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	switch opts["input"] {
+	case "", "path":
+	case "stdin":
+		cmd.Stdin = reader
+	default:
+		return fmt.Errorf("exec: bad input %q (expecting path or stdin)", opts["input"])
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// The command gets a process group of its own, so that on a timeout
+	// anything it started (that might be holding its output open) is
+	// killed along with it.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("exec %s: %s", args[0], err)
+	}
+
+	var timedOut int32
+	kill := func() { syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	timer := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		kill()
+	})
+	defer timer.Stop()
+
+	// Keep reading after a bad line so the command isn't blocked on a full
+	// pipe, but only report the first one.
+	var parseErr error
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxExecLine)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 && parseErr == nil {
+			parseErr = fmt.Errorf("exec %s: expecting at most two timestamps per line, got %q",
+				args[0], scanner.Text())
+		}
+		for _, field := range fields {
+			t, err := tf_time.UnmarshalTime([]byte(field))
+			if err != nil {
+				res.Failed++
+				if parseErr == nil {
+					parseErr = fmt.Errorf("exec %s: bad timestamp %q: %s", args[0], field, err)
+				}
+				continue
+			}
+			res.Add(t)
+		}
+	}
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		// We can't read the rest, and the command would block writing it.
+		kill()
+		io.Copy(io.Discard, stdout)
+		scanErr = fmt.Errorf("exec %s: reading output: %s", args[0], scanErr)
+	}
+
+	corrupt := func(err error) error {
+		return &CorruptFileError{Filename: filename, Records: int(res.Parsed), Err: err}
+	}
+	err = cmd.Wait()
+	switch {
+	case atomic.LoadInt32(&timedOut) != 0:
+		return corrupt(fmt.Errorf("exec %s: timed out after %s", args[0], timeout))
+	case scanErr != nil:
+		return corrupt(scanErr)
+	case err != nil:
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxExecStderr {
+			msg = "..." + msg[len(msg)-maxExecStderr:]
+		}
+		return corrupt(fmt.Errorf("exec %s: %s: %s", args[0], err, msg))
+	case parseErr != nil:
+		return corrupt(parseErr)
+	}
+
+	return nil
+}
+
+// SplitCommand splits a command line into words at white space, honoring
+// single and double quotes (but no other shell syntax).
+func SplitCommand(command string) (args []string, err error) {
+	var word []rune
+	var quote rune
+	inWord := false
+
+	for _, c := range command {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word = append(word, c)
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				args = append(args, string(word))
+				word = word[:0]
+				inWord = false
+			}
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("exec: unterminated %c in command %q", quote, command)
+	}
+	if inWord {
+		args = append(args, string(word))
+	}
+
+	return args, nil
+}
+
+func copyToFile(filename string, r io.Reader) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func init() {
+	Register("exec", execProcessor{})
+}
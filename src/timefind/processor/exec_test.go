@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecProcessor(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		input   string
+		parsed  int64
+		err     string // A substring of the expected error, if any
+	}{
+		{"times", `printf '1436000000\n1436000100.5 1436000200\n'`, "", 3, ""},
+		{"stdin", `sed -n 's/^t=//p'`, "t=1436000000\nx\nt=1436000100\n", 2, ""},
+		{"bad time", `echo 1436000000 tomorrow`, "", 1, "bad timestamp"},
+		{"too many", `echo 1 2 3`, "", 3, "at most two"},
+		{"exit status", `sh -c 'echo 1436000000; echo oops >&2; exit 3'`, "", 0, "oops"},
+		// A line too long to read, with more output after it that the
+		// command would block writing.
+		{"long line", `sh -c 'head -c 2000000 /dev/zero | tr "\0" 1; echo; head -c 1000000 /dev/zero'`,
+			"", 0, "too long"},
+	}
+
+	for _, test := range tests {
+		opts := Options{"command": test.command, "timeout": "20s"}
+		if test.input != "" {
+			opts["input"] = "stdin"
+		}
+		res := Result{}
+		start := time.Now()
+		err := execProcessor{}.Process(strings.NewReader(test.input), "/data/x.log", opts, &res)
+		if time.Since(start) > 10*time.Second {
+			t.Errorf("%s: took %s", test.name, time.Since(start))
+		}
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, expected one with %q", test.name, err, test.err)
+		case test.err != "":
+			// A bad file mustn't stop the rest of the source being indexed.
+			if _, ok := err.(*CorruptFileError); !ok {
+				t.Errorf("%s: got %T, expected a CorruptFileError", test.name, err)
+			}
+		case test.err == "" && res.Parsed != test.parsed:
+			t.Errorf("%s: parsed %d times, expected %d", test.name, res.Parsed, test.parsed)
+		}
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	// The command exits at once, but leaves a child holding its output
+	// open. The timeout has to kill that too.
+	opts := Options{"command": `sh -c 'sleep 30 & echo 1436000000'`, "timeout": "500ms"}
+	start := time.Now()
+	err := execProcessor{}.Process(strings.NewReader(""), "/data/x.log", opts, &Result{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got error %v, expected a timeout", err)
+	} else if _, ok := err.(*CorruptFileError); !ok {
+		t.Errorf("got %T, expected a CorruptFileError", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("took %s to time out", elapsed)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"tshark -r {}", []string{"tshark", "-r", "{}"}},
+		{`a  'b c' "d 'e'" f""g`, []string{"a", "b c", "d 'e'", "fg"}},
+		{`'' x`, []string{"", "x"}},
+	}
+	for _, test := range tests {
+		got, err := SplitCommand(test.in)
+		if err != nil || strings.Join(got, "|") != strings.Join(test.want, "|") || len(got) != len(test.want) {
+			t.Errorf("SplitCommand(%q) = %q, %v, expected %q", test.in, got, err, test.want)
+		}
+	}
+	if _, err := SplitCommand(`a 'b`); err == nil {
+		t.Errorf("SplitCommand with an unterminated quote: expected an error")
+	}
+}
@@ -0,0 +1,567 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Record is one record of a data file, with its time.
+type Record struct {
+	Time time.Time
+	Data []byte // The record as it's written out by Extract
+}
+
+// A RecordReader reads the records of one data file in file order.
+type RecordReader interface {
+	// Header returns the bytes at the start of the file that precede the
+	// first record, exactly as they appear in the file.
+	Header() []byte
+
+	// Next returns the next record, or io.EOF at the end of the file.
+	Next() (Record, error)
+}
+
+// An Extractor is a Processor that can also read the records of a data file
+// one at a time, so that just the records in a time range can be extracted.
+type Extractor interface {
+	Processor
+
+	// Records returns a RecordReader for the data file whose
+	// (decompressed) contents are read from reader. Times without a zone
+	// in it are in loc.
+	Records(reader io.Reader, filename string, opts Options, loc *time.Location) (RecordReader, error)
+
+	// MergeHeaders returns the header for a file holding the records of
+	// files with the given headers, or an error if there can't be one.
+	MergeHeaders(headers [][]byte) ([]byte, error)
+}
+
+// An ExtractFile is a data file to extract records from.
+type ExtractFile struct {
+	Filename string
+	Offset   int64     // Where its records from the start time on begin (see Checkpoint)
+	Earliest time.Time // The earliest record in the file, from the index
+	Latest   time.Time // The latest record in the file, from the index
+}
+
+// Extract writes a header, and then the records of files from earliest to
+// latest (inclusive), to w. Records from different files are merged in time
+// order, assuming each file's records are in order. Files are only opened
+// once the records before them have been written. Times without a zone in the
+// files are in loc. A file that has no records at all, though the index gives
+// it a period, is an error rather than being left out.
+func Extract(w io.Writer, p Extractor, opts Options, loc *time.Location, files []ExtractFile,
+	earliest time.Time, latest time.Time) error {
+
+	files = append([]ExtractFile{}, files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Earliest.Before(files[j].Earliest)
+	})
+
+	headers := make([][]byte, len(files))
+	for i, file := range files {
+		var err error
+		if headers[i], err = readHeader(p, opts, loc, file.Filename); err != nil {
+			return err
+		}
+	}
+	header, err := p.MergeHeaders(headers)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	h := &recordHeap{}
+	defer h.closeAll()
+
+	next := 0
+	for {
+		// Open every file that might have records earlier than the
+		// earliest one we have.
+		for next < len(files) && (h.Len() == 0 || !files[next].Earliest.After((*h)[0].record.Time)) {
+			s, err := openRecords(p, opts, loc, files[next], headers[next], next)
+			if err != nil {
+				return err
+			}
+			next++
+			if err := h.push(s, earliest); err != nil {
+				return err
+			}
+		}
+		if h.Len() == 0 {
+			break
+		}
+
+		s := (*h)[0]
+		if s.record.Time.After(latest) {
+			// Nothing after this in the file is wanted.
+			s.Close()
+			heap.Pop(h)
+			continue
+		}
+		if _, err := bw.Write(s.record.Data); err != nil {
+			return err
+		}
+		if err := h.advance(earliest); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// A recordStream is an open data file and its next record.
+type recordStream struct {
+	io.Closer
+	records  RecordReader
+	record   Record
+	filename string
+	order    int
+	empty    bool // Whether the index says the file has no records
+	read     int  // Records read so far
+}
+
+// recordHeap keeps the streams ordered by the time of their next record.
+type recordHeap []*recordStream
+
+func (h recordHeap) Len() int { return len(h) }
+func (h recordHeap) Less(i, j int) bool {
+	if h[i].record.Time.Equal(h[j].record.Time) {
+		return h[i].order < h[j].order
+	}
+	return h[i].record.Time.Before(h[j].record.Time)
+}
+func (h recordHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *recordHeap) Push(x interface{}) { *h = append(*h, x.(*recordStream)) }
+func (h *recordHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// push reads the first record at or after earliest from s and adds s to the
+// heap, or closes it if there isn't one.
+func (h *recordHeap) push(s *recordStream, earliest time.Time) error {
+	ok, err := s.skipTo(earliest)
+	if err != nil || !ok {
+		s.Close()
+		return err
+	}
+	heap.Push(h, s)
+	return nil
+}
+
+// advance moves the first stream in the heap on to its next record.
+func (h *recordHeap) advance(earliest time.Time) error {
+	s := (*h)[0]
+	ok, err := s.skipTo(earliest)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.Close()
+		heap.Pop(h)
+		return nil
+	}
+	heap.Fix(h, 0)
+	return nil
+}
+
+func (h *recordHeap) closeAll() {
+	for _, s := range *h {
+		s.Close()
+	}
+}
+
+// skipTo reads records until one at or after earliest. ok is false at the
+// end of the file.
+func (s *recordStream) skipTo(earliest time.Time) (ok bool, err error) {
+	for {
+		s.record, err = s.records.Next()
+		if err == io.EOF {
+			if s.read == 0 && !s.empty {
+				// Rather than leave its records out without a word.
+				return false, fmt.Errorf("%s: no records found, but the index has some", s.filename)
+			}
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("%s: %s", s.filename, err)
+		}
+		s.read++
+		if !s.record.Time.Before(earliest) {
+			return true, nil
+		}
+	}
+}
+
+// openRecords opens file for reading records. order breaks ties between records
+// with the same time in different files.
+func openRecords(p Extractor, opts Options, loc *time.Location, file ExtractFile, header []byte, order int) (*recordStream, error) {
+	var reader io.Reader
+	var closer io.Closer
+	if file.Offset > 0 {
+		// Skip to the checkpoint, but put the header back in front.
+		rc, err := OpenAt(file.Filename, file.Offset)
+		if err != nil {
+			return nil, err
+		}
+		reader, closer = io.MultiReader(bytes.NewReader(header), rc), rc
+	} else {
+		var err error
+		if reader, closer, err = openData(file.Filename); err != nil {
+			return nil, err
+		}
+	}
+
+	records, err := p.Records(reader, file.Filename, opts, loc)
+	if err != nil {
+		closer.Close()
+		return nil, fmt.Errorf("%s: %s", file.Filename, err)
+	}
+
+	return &recordStream{Closer: closer, records: records, filename: file.Filename, order: order,
+		empty: file.Earliest.IsZero() && file.Latest.IsZero()}, nil
+}
+
+func readHeader(p Extractor, opts Options, loc *time.Location, filename string) ([]byte, error) {
+	reader, closer, err := openData(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	records, err := p.Records(reader, filename, opts, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return records.Header(), nil
+}
+
+// openData opens the data file filename, which may be an archive member, and
+// decompresses it.
+func openData(filename string) (io.Reader, io.Closer, error) {
+	if _, member, ok := SplitArchivePath(filename); ok {
+		rc, err := OpenMember(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader, err := Decompress(rc, member)
+		if err != nil {
+			rc.Close()
+			return nil, nil, err
+		}
+		return reader, rc, nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := OpenFile(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return reader, f, nil
+}
+
+// lineRecords reads a file of lines, each starting with a time that parse can
+// find. Lines before the first one with a time are the header, and later lines
+// without a time belong to the record before them.
+type lineRecords struct {
+	r       *bufio.Reader
+	parse   func(line string) (time.Time, error)
+	header  []byte
+	pending *Record // The next record, once it's been read
+}
+
+func newLineRecords(reader io.Reader, parse func(line string) (time.Time, error)) (*lineRecords, error) {
+	lr := &lineRecords{r: bufio.NewReader(reader), parse: parse}
+	for {
+		line, err := lr.r.ReadString('\n')
+		if len(line) > 0 {
+			if t, perr := parse(strings.TrimRight(line, "\r\n")); perr == nil {
+				lr.pending = &Record{Time: t, Data: []byte(line)}
+				return lr, nil
+			}
+			lr.header = append(lr.header, line...)
+		}
+		if err == io.EOF {
+			return lr, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (lr *lineRecords) Header() []byte { return lr.header }
+
+func (lr *lineRecords) Next() (Record, error) {
+	if lr.pending == nil {
+		return Record{}, io.EOF
+	}
+	record := *lr.pending
+	lr.pending = nil
+
+	for {
+		line, err := lr.r.ReadString('\n')
+		if len(line) > 0 {
+			if t, perr := lr.parse(strings.TrimRight(line, "\r\n")); perr == nil {
+				lr.pending = &Record{Time: t, Data: []byte(line)}
+				return record, nil
+			}
+			record.Data = append(record.Data, line...)
+		}
+		if err == io.EOF {
+			return record, nil
+		} else if err != nil {
+			return Record{}, err
+		}
+	}
+}
+
+// firstHeader is MergeHeaders for line-based files: it uses the header of the
+// first file that has one.
+func firstHeader(headers [][]byte) ([]byte, error) {
+	for _, header := range headers {
+		if len(header) > 0 {
+			return header, nil
+		}
+	}
+	return nil, nil
+}
+
+/*
+  pcap files are written out in little-endian, microsecond pcap format,
+  whatever format they were read in.
+*/
+
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+)
+
+var errPcapHeader = errors.New("not a pcap file")
+
+type pcapRecords struct {
+	header []byte
+	order  binary.ByteOrder
+	nano   bool
+	r      io.Reader
+}
+
+func newPcapRecords(reader io.Reader) (*pcapRecords, error) {
+	pr := &pcapRecords{header: make([]byte, 24), r: reader}
+	if _, err := io.ReadFull(reader, pr.header); err != nil {
+		return nil, errPcapHeader
+	}
+
+	var err error
+	if pr.order, pr.nano, err = pcapOrder(pr.header); err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+func pcapOrder(header []byte) (order binary.ByteOrder, nano bool, err error) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(header) {
+		case pcapMagicMicro:
+			return order, false, nil
+		case pcapMagicNano:
+			return order, true, nil
+		}
+	}
+	return nil, false, errPcapHeader
+}
+
+func (pr *pcapRecords) Header() []byte { return pr.header }
+
+func (pr *pcapRecords) Next() (Record, error) {
+	data := make([]byte, 16)
+	if _, err := io.ReadFull(pr.r, data); err == io.EOF {
+		return Record{}, io.EOF
+	} else if err != nil {
+		return Record{}, ErrTruncated
+	}
+
+	sec := pr.order.Uint32(data[0:])
+	frac := pr.order.Uint32(data[4:])
+	caplen := pr.order.Uint32(data[8:])
+	origlen := pr.order.Uint32(data[12:])
+	if caplen > 1<<26 {
+		return Record{}, ErrCorrupt
+	}
+
+	t := time.Unix(int64(sec), int64(frac)*1000)
+	if pr.nano {
+		t = time.Unix(int64(sec), int64(frac))
+		frac /= 1000
+	}
+
+	binary.LittleEndian.PutUint32(data[0:], sec)
+	binary.LittleEndian.PutUint32(data[4:], frac)
+	binary.LittleEndian.PutUint32(data[8:], caplen)
+	binary.LittleEndian.PutUint32(data[12:], origlen)
+
+	data = append(data, make([]byte, caplen)...)
+	if _, err := io.ReadFull(pr.r, data[16:]); err != nil {
+		return Record{}, ErrTruncated
+	}
+
+	return Record{Time: t.UTC(), Data: data}, nil
+}
+
+// mergePcapHeaders returns a header for pcap files with the same link type,
+// and the largest snapshot length of any of them.
+func mergePcapHeaders(headers [][]byte) ([]byte, error) {
+	merged := make([]byte, 24)
+	binary.LittleEndian.PutUint32(merged[0:], pcapMagicMicro)
+	binary.LittleEndian.PutUint16(merged[4:], 2)
+	binary.LittleEndian.PutUint16(merged[6:], 4)
+
+	snaplen, linktype := uint32(0), uint32(0)
+	for i, header := range headers {
+		order, _, err := pcapOrder(header)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && order.Uint32(header[20:]) != linktype {
+			return nil, fmt.Errorf("can't merge pcap files with link types %d and %d",
+				linktype, order.Uint32(header[20:]))
+		}
+		linktype = order.Uint32(header[20:])
+		if order.Uint32(header[16:]) > snaplen {
+			snaplen = order.Uint32(header[16:])
+		}
+	}
+
+	binary.LittleEndian.PutUint32(merged[16:], snaplen)
+	binary.LittleEndian.PutUint32(merged[20:], linktype)
+	return merged, nil
+}
+
+/*
+  MRT files have no header, so they're written out as the records
+  themselves.
+*/
+
+type mrtRecords struct {
+	r io.Reader
+}
+
+func (mr *mrtRecords) Header() []byte { return nil }
+
+func (mr *mrtRecords) Next() (Record, error) {
+	data := make([]byte, mrtHeaderLen)
+	if _, err := io.ReadFull(mr.r, data); err == io.EOF {
+		return Record{}, io.EOF
+	} else if err != nil {
+		return Record{}, ErrTruncated
+	}
+
+	sec := binary.BigEndian.Uint32(data[0:])
+	typ := binary.BigEndian.Uint16(data[4:])
+	length := binary.BigEndian.Uint32(data[8:])
+	if !mrtTypes[typ] || length > mrtMaxRecordLen {
+		return Record{}, ErrCorrupt
+	}
+
+	data = append(data, make([]byte, length)...)
+	if _, err := io.ReadFull(mr.r, data[mrtHeaderLen:]); err != nil {
+		return Record{}, ErrTruncated
+	}
+
+	t := time.Unix(int64(sec), 0)
+	switch typ {
+	case mrtTypeBGP4MP_ET, mrtTypeISIS_ET, mrtTypeOSPFv3_ET:
+		if length < 4 {
+			return Record{}, ErrCorrupt
+		}
+		us := binary.BigEndian.Uint32(data[mrtHeaderLen:])
+		if us >= 1000000 {
+			return Record{}, ErrCorrupt
+		}
+		t = t.Add(time.Duration(us) * time.Microsecond)
+	}
+
+	return Record{Time: t.UTC(), Data: data}, nil
+}
+
+// The builtin processors that can be extracted from.
+var extractors = map[string]struct {
+	records func(reader io.Reader, filename string, loc *time.Location) (RecordReader, error)
+	merge   func(headers [][]byte) ([]byte, error)
+}{
+	"pcap": {
+		func(reader io.Reader, filename string, loc *time.Location) (RecordReader, error) {
+			return newPcapRecords(reader)
+		},
+		mergePcapHeaders,
+	},
+	"mrt": {
+		func(reader io.Reader, filename string, loc *time.Location) (RecordReader, error) {
+			return &mrtRecords{reader}, nil
+		},
+		func(headers [][]byte) ([]byte, error) { return nil, nil },
+	},
+	"fsdb_time_col_1": {
+		func(reader io.Reader, filename string, loc *time.Location) (RecordReader, error) {
+			return newLineRecords(reader, func(line string) (time.Time, error) {
+				return fsdbTime(line, 1)
+			})
+		},
+		firstHeader,
+	},
+	"fsdb_time_col_2": {
+		func(reader io.Reader, filename string, loc *time.Location) (RecordReader, error) {
+			return newLineRecords(reader, func(line string) (time.Time, error) {
+				return fsdbTime(line, 2)
+			})
+		},
+		firstHeader,
+	},
+	"cpp": {
+		func(reader io.Reader, filename string, loc *time.Location) (RecordReader, error) {
+			return newLineRecords(reader, cppTime)
+		},
+		firstHeader,
+	},
+	"text": {
+		func(reader io.Reader, filename string, loc *time.Location) (RecordReader, error) {
+			return newLineRecords(reader, func(line string) (time.Time, error) {
+				return textTime(line, filename, loc)
+			})
+		},
+		firstHeader,
+	},
+}
+
+// extractor adds the Extractor methods to a builtin processor.
+type extractor struct {
+	builtin
+	records func(reader io.Reader, filename string, loc *time.Location) (RecordReader, error)
+	merge   func(headers [][]byte) ([]byte, error)
+}
+
+func (x extractor) Records(reader io.Reader, filename string, opts Options, loc *time.Location) (RecordReader, error) {
+	return x.records(reader, filename, loc)
+}
+
+func (x extractor) MergeHeaders(headers [][]byte) ([]byte, error) {
+	return x.merge(headers)
+}
@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"timefind/internal/fixture"
+)
+
+func testExtractor(t *testing.T, name string) Extractor {
+	p, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("no processor %s", name)
+	}
+	x, ok := p.(Extractor)
+	if !ok {
+		t.Fatalf("%s isn't an Extractor", name)
+	}
+	return x
+}
+
+// writeTestFiles writes each of data to a file in dir, and returns their
+// ExtractFiles with the period given by times (earliest and latest for each).
+func writeTestFiles(t *testing.T, dir string, names []string, data [][]byte, times [][2]int64) []ExtractFile {
+	files := []ExtractFile{}
+	for i, name := range names {
+		filename := filepath.Join(dir, name)
+		fixture.Files(t, dir, map[string]string{name: string(data[i])})
+		files = append(files, ExtractFile{Filename: filename,
+			Earliest: time.Unix(times[i][0], 0), Latest: time.Unix(times[i][1], 0)})
+	}
+	return files
+}
+
+func TestExtractMerge(t *testing.T) {
+	x := testExtractor(t, "fsdb_time_col_1")
+	dir := t.TempDir()
+	files := writeTestFiles(t, dir, []string{"a.fsdb", "b.fsdb", "c.fsdb"},
+		[][]byte{
+			[]byte("#fsdb -F t time name\n100\ta1\n103\ta2\n#  | continued\n106\ta3\n"),
+			[]byte("#fsdb -F t time name\n101\tb1\n103\tb2\n104\tb3\n"),
+			[]byte("#fsdb -F t time name\n200\tc1\n"),
+		},
+		[][2]int64{{100, 106}, {101, 104}, {200, 200}})
+
+	tests := []struct {
+		name     string
+		earliest int64
+		latest   int64
+		want     string
+	}{
+		{"all", 0, 300,
+			"#fsdb -F t time name\n100\ta1\n101\tb1\n103\ta2\n#  | continued\n103\tb2\n104\tb3\n106\ta3\n200\tc1\n"},
+		// The same time in two files comes out in file order, and the
+		// ends are included.
+		{"window", 103, 104, "#fsdb -F t time name\n103\ta2\n#  | continued\n103\tb2\n104\tb3\n"},
+		{"none", 150, 160, "#fsdb -F t time name\n"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		// Files are given in any order.
+		err := Extract(&buf, x, nil, time.UTC, []ExtractFile{files[2], files[1], files[0]},
+			time.Unix(test.earliest, 0), time.Unix(test.latest, 0))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if buf.String() != test.want {
+			t.Errorf("%s: got\n%s\nexpected\n%s", test.name, buf.String(), test.want)
+		}
+	}
+
+	// From a checkpoint, the header still comes first.
+	offset := int64(strings.Index("#fsdb -F t time name\n100\ta1\n103\ta2\n", "103"))
+	file := files[0]
+	file.Offset = offset
+	var buf bytes.Buffer
+	if err := Extract(&buf, x, nil, time.UTC, []ExtractFile{file}, time.Unix(103, 0), time.Unix(300, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "#fsdb -F t time name\n103\ta2\n#  | continued\n106\ta3\n"; buf.String() != want {
+		t.Errorf("from offset %d: got\n%s\nexpected\n%s", offset, buf.String(), want)
+	}
+}
+
+type pcapPacket struct {
+	sec  uint32
+	frac uint32 // Microseconds, or nanoseconds in a nanosecond pcap
+	data string
+}
+
+func pcapFile(order binary.ByteOrder, nano bool, snaplen uint32, linktype uint32, packets ...pcapPacket) []byte {
+	var buf bytes.Buffer
+	magic := uint32(pcapMagicMicro)
+	if nano {
+		magic = pcapMagicNano
+	}
+	binary.Write(&buf, order, []uint32{magic})
+	binary.Write(&buf, order, []uint16{2, 4})
+	binary.Write(&buf, order, []uint32{0, 0, snaplen, linktype})
+	for _, p := range packets {
+		binary.Write(&buf, order, []uint32{p.sec, p.frac, uint32(len(p.data)), uint32(len(p.data))})
+		buf.WriteString(p.data)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractPcap(t *testing.T) {
+	x := testExtractor(t, "pcap")
+	dir := t.TempDir()
+
+	files := writeTestFiles(t, dir, []string{"a.pcap", "b.pcap"},
+		[][]byte{
+			pcapFile(binary.BigEndian, false, 1500, 1,
+				pcapPacket{100, 500000, "a1"}, pcapPacket{102, 0, "a2"}),
+			pcapFile(binary.LittleEndian, true, 65535, 1,
+				pcapPacket{100, 750000000, "b1"}, pcapPacket{101, 1999, "b2"}),
+		},
+		[][2]int64{{100, 102}, {100, 101}})
+
+	// Little-endian and microseconds, whatever the input, with the
+	// largest snapshot length.
+	want := pcapFile(binary.LittleEndian, false, 65535, 1,
+		pcapPacket{100, 500000, "a1"}, pcapPacket{100, 750000, "b1"},
+		pcapPacket{101, 1, "b2"}, pcapPacket{102, 0, "a2"})
+
+	var buf bytes.Buffer
+	if err := Extract(&buf, x, nil, time.UTC, files, time.Unix(0, 0), time.Unix(200, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got\n%x\nexpected\n%x", buf.Bytes(), want)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"link type", pcapFile(binary.LittleEndian, false, 1500, 105), "link types"},
+		{"not pcap", []byte("not a pcap file, but long enough"), "not a pcap file"},
+		{"truncated", pcapFile(binary.LittleEndian, false, 1500, 1, pcapPacket{100, 0, "abcd"})[:40],
+			ErrTruncated.Error()},
+	}
+	for _, test := range tests {
+		bad := writeTestFiles(t, dir, []string{"bad.pcap"}, [][]byte{test.data}, [][2]int64{{100, 100}})
+		err := Extract(&bytes.Buffer{}, x, nil, time.UTC, append(bad, files...),
+			time.Unix(0, 0), time.Unix(200, 0))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, expected %q", test.name, err, test.err)
+		}
+	}
+}
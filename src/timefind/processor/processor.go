@@ -0,0 +1,306 @@
+package processor
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tf_time "timefind/time"
+
+	"xi2.org/x/xz"
+)
+
+// A Processor finds the earliest and latest time in a data file of one
+// particular format. The built-in processors are registered by this package;
+// out-of-tree processors can be added by calling Register from the init
+// function of their own package and linking that package into a custom
+// timefind_indexer, e.g.:
+//
+//	import (
+//		"timefind/processor"
+//		_ "example.org/ourformats"
+//	)
+type Processor interface {
+	// Name returns the processor's preferred "type" name.
+	Name() string
+
+	// Options returns the keys this processor accepts in the "options"
+	// object of a source configuration file.
+	Options() []string
+
+	// Process reads a data file's (decompressed) contents from reader and
+	// adds every record it finds to res. filename is the path of the data
+	// file, which some processors take hints from; it may name an archive
+	// member ("ARCHIVE!MEMBER"). res is discarded if Process fails.
+	Process(reader io.Reader, filename string, opts Options, res *Result) error
+}
+
+// Options holds the per-source processor options from a configuration file.
+type Options map[string]string
+
+// Stats counts the records (lines, packets, ...) a processor looked at. A
+// record with no time in it at all, such as a comment, header or line that
+// doesn't match the type's pattern, is Skipped; one whose time was found
+// but couldn't be parsed is Failed.
+type Stats struct {
+	Parsed  int64 // Records whose time was read
+	Skipped int64 // Records without a time, like comments and headers
+	Failed  int64 // Records with a time that couldn't be parsed (or was bogus)
+}
+
+// Total returns the number of records counted.
+func (s Stats) Total() int64 {
+	return s.Parsed + s.Skipped + s.Failed
+}
+
+// Sum adds the counts in other to s.
+func (s *Stats) Sum(other Stats) {
+	s.Parsed += other.Parsed
+	s.Skipped += other.Skipped
+	s.Failed += other.Failed
+}
+
+// A Result accumulates the period and record counts of one data file.
+type Result struct {
+	Period tf_time.Times
+	Stats
+
+	// If not nil, which parts of the period have records in them.
+	Coverage *Coverage
+
+	// If not nil, where a reader can start reading the data; see AddAt.
+	Checkpoints *Checkpoints
+
+	location *time.Location // Of times without a zone; see Parse
+}
+
+// Location returns the time zone that times without one in the data file are
+// in (the source's "timezone").
+func (res *Result) Location() *time.Location {
+	if res.location == nil {
+		return time.UTC
+	}
+	return res.location
+}
+
+// Parse parses a time from the data file as time.Parse does, except that a
+// time without a zone is taken to be in res.Location() rather than UTC.
+func (res *Result) Parse(layout string, value string) (time.Time, error) {
+	return time.ParseInLocation(layout, value, res.Location())
+}
+
+// Add records a parsed time.
+func (res *Result) Add(t time.Time) {
+	t = t.UTC()
+	if res.Coverage != nil {
+		res.Coverage.add(t)
+	}
+	if res.Period.Earliest.IsZero() || t.Before(res.Period.Earliest) {
+		res.Period.Earliest = t
+	}
+	if res.Period.Latest.IsZero() || t.After(res.Period.Latest) {
+		res.Period.Latest = t
+	}
+	res.Parsed++
+}
+
+// AddParsed records the result of parsing a time, e.g.,
+//
+//	res.AddParsed(time.Parse(layout, str))
+//
+// Times that couldn't be parsed are counted as failed rather than added.
+func (res *Result) AddParsed(t time.Time, err error) {
+	if err != nil {
+		res.Failed++
+		return
+	}
+	res.Add(t)
+}
+
+var (
+	processorsMu sync.RWMutex
+	processors   = map[string]Processor{}
+)
+
+// Register makes a processor available as the configuration "type" name.
+// Registering the same name twice, or a nil processor, panics.
+func Register(name string, p Processor) {
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+
+	if p == nil {
+		panic("processor: Register processor is nil")
+	}
+	if _, dup := processors[name]; dup {
+		panic("processor: Register called twice for processor " + name)
+	}
+	processors[name] = p
+}
+
+// Lookup returns the processor registered as name.
+func Lookup(name string) (Processor, bool) {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+
+	p, ok := processors[name]
+	return p, ok
+}
+
+// Names returns the sorted names of all registered processors.
+func Names() []string {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+
+	names := make([]string, 0, len(processors))
+	for name := range processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckOptions returns an error if opts contains a key that p doesn't accept.
+func CheckOptions(p Processor, opts Options) error {
+	for key := range opts {
+		found := false
+		for _, known := range p.Options() {
+			if key == known {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("processor %s: unknown option %q", p.Name(), key)
+		}
+	}
+	return nil
+}
+
+// Extras selects what a Result records besides the period and record counts,
+// and how times are read.
+type Extras struct {
+	Coverage    time.Duration  // The size of Coverage slots, or 0 for none
+	Checkpoints int64          // Bytes between Checkpoints, or 0 for none
+	Location    *time.Location // Of times without a zone in the data; UTC if nil
+}
+
+func (x Extras) newResult() Result {
+	res := Result{location: x.Location}
+	if x.Coverage > 0 {
+		res.Coverage = NewCoverage(x.Coverage)
+	}
+	if x.Checkpoints > 0 {
+		res.Checkpoints = NewCheckpoints(x.Checkpoints)
+	}
+	return res
+}
+
+// ProcessFile opens and decompresses filename and runs p over its contents.
+func ProcessFile(p Processor, filename string, opts Options, extras Extras) (res Result, err error) {
+	res = extras.newResult()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+
+	reader, err := OpenFile(f)
+	if err != nil {
+		log.Printf("error is getting an io.Reader: %s", err)
+		return res, err
+	}
+
+	if err := p.Process(reader, filename, opts, &res); err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// UncompressedSize returns the size of the contents of filename after
+// Decompress, if that can be found without decompressing it: for plain files
+// it's their size, and for gzip files it comes from the gzip trailer. ok is
+// false for bzip2 and xz files.
+//
+// The gzip trailer only holds the size modulo 4 GiB. Since gzip never makes
+// data much bigger, the size is taken to be the smallest one that fits and is
+// at least half the size of the file. That's right for everything but the
+// largest, most compressible files, and too low for those.
+func UncompressedSize(filename string) (size int64, ok bool, err error) {
+	if strings.HasSuffix(filename, ".bz2") || strings.HasSuffix(filename, ".xz") {
+		return 0, false, nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+
+	magic := make([]byte, 2)
+	if n, _ := io.ReadFull(f, magic); n < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return info.Size(), true, nil
+	}
+
+	// The last four bytes of a gzip file are ISIZE, little-endian.
+	trailer := make([]byte, 4)
+	if info.Size() < 18 {
+		return 0, false, nil
+	}
+	if _, err := f.ReadAt(trailer, info.Size()-4); err != nil {
+		return 0, false, err
+	}
+	size = int64(binary.LittleEndian.Uint32(trailer))
+	for size < info.Size()/2 {
+		size += 1 << 32
+	}
+	return size, true, nil
+}
+
+func OpenFile(f *os.File) (reader io.Reader, err error) {
+	return Decompress(f, f.Name())
+}
+
+// Decompress wraps r in a decompressor chosen by the suffix of filename. gzip
+// is recognized by its magic number instead, since some sources don't name
+// their gzipped files consistently.
+func Decompress(r io.Reader, filename string) (reader io.Reader, err error) {
+	if strings.HasSuffix(filename, ".bz2") {
+		// handle bz2 -- no bzip2.Close() or error return...
+		bf := bzip2.NewReader(r)
+		reader = bf
+	} else if strings.HasSuffix(filename, ".xz") {
+		// handle xz
+		xf, err := xz.NewReader(r, 0)
+		if err != nil {
+			log.Printf("error reading .xz file = %s, skipping...\n", err)
+			return reader, err
+		} else {
+			reader = xf
+			// XXX xz has no xz.Close()
+		}
+	} else {
+		// gzip, or just a plain file
+		br := bufio.NewReader(r)
+		if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+			return gzip.NewReader(br)
+		}
+		reader = br
+	}
+
+	return reader, nil
+}
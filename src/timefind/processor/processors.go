@@ -2,11 +2,11 @@ package processor
 
 import (
 	"bufio"
-	"compress/bzip2"
-	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"log"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -14,18 +14,36 @@ import (
 
 	tf_time "timefind/time"
 
-	mrt "github.com/kaorimatz/go-mrt"
 	"woozle.org/neale/g.cgi/net/go-pcap.git"
-	"xi2.org/x/xz"
 )
 
 // An internal counter for debugging purposes
 var counter int
 
-// The processor functions are defined in the processors module
-type ProcessFunction func(filename string) (tf_time.Times, error)
+// Errors wrapped by CorruptFileError.
+var (
+	ErrTruncated = errors.New("truncated record")
+	ErrCorrupt   = errors.New("corrupt record")
+)
+
+// A CorruptFileError is returned by a processor when a data file cannot be
+// read to the end. Whatever was read before the bad record is discarded, so
+// that a damaged file is never indexed with a partial period that looks valid.
+type CorruptFileError struct {
+	Filename string
+	Records  int   // Records read successfully before the error
+	Offset   int64 // Byte offset of the bad record (after decompression)
+	Err      error // ErrTruncated, ErrCorrupt, or the error reading the data
+}
+
+func (e *CorruptFileError) Error() string {
+	return fmt.Sprintf("%s: %s at offset %d after %d records",
+		e.Filename, e.Err, e.Offset, e.Records)
+}
 
-var Processors map[string]ProcessFunction = map[string]ProcessFunction{
+// The built-in processors. Each reads already decompressed data from reader;
+// filename is only used by processors that take hints (like the year) from it.
+var builtins = map[string]func(reader io.Reader, filename string, res *Result) error{
 	"bluecoat":        process_bluecoat,
 	"bomgar":          process_bomgar,
 	"cer":             process_cer,
@@ -47,739 +65,419 @@ var Processors map[string]ProcessFunction = map[string]ProcessFunction{
 	"wireless":        process_wireless,
 }
 
-func process_cpp(filename string) (times tf_time.Times, err error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
+// builtin adapts one of the functions above to the Processor interface.
+type builtin struct {
+	name    string
+	process func(reader io.Reader, filename string, res *Result) error
+}
+
+func (b builtin) Name() string      { return b.name }
+func (b builtin) Options() []string { return nil }
+
+func (b builtin) Process(reader io.Reader, filename string, opts Options, res *Result) error {
+	return b.process(reader, filename, res)
+}
+
+func init() {
+	for name, process := range builtins {
+		if x, ok := extractors[name]; ok {
+			Register(name, extractor{builtin{name, process}, x.records, x.merge})
+		} else {
+			Register(name, builtin{name, process})
+		}
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
+}
+
+func process_cpp(reader io.Reader, filename string, res *Result) error {
+	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		line := scanner.Text()
-		split := strings.Split(line, ",")
-		second := split[0]
-		day := strings.Split(second, ".")
-		when := day[0]
-		t, err := strconv.ParseInt(when, 10, 64)
+		date, err := cppTime(scanner.Text())
 		if err != nil {
-			return times, err
-		}
-		tm := time.Unix(t, 0)
-		date := tm.UTC()
-		if times.Earliest.IsZero() || date.Before(times.Earliest) {
-			times.Earliest = date
-		}
-		if times.Latest.IsZero() || date.After(times.Latest) {
-			times.Latest = date
+			return err
 		}
+		res.Add(date)
 	}
 
-	return times, nil
+	return nil
 }
 
-func process_bomgar(filename string) (times tf_time.Times, err error) {
-	var reader io.Reader
-
-	f, err := os.Open(filename)
+// cppTime returns the time of a line of a "cpp" file: the whole seconds of
+// the Unix timestamp in its first column.
+func cppTime(line string) (time.Time, error) {
+	split := strings.Split(line, ",")
+	second := split[0]
+	day := strings.Split(second, ".")
+	when := day[0]
+	t, err := strconv.ParseInt(when, 10, 64)
 	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
+		return time.Time{}, err
 	}
+	tm := time.Unix(t, 0)
+	return tm.UTC(), nil
+}
 
+func process_bomgar(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, err := regexp.Compile("when=[0-9]{1,10}")
 		if err != nil {
-			return times, err
+			return err
 		}
 		str := r.FindString(line)
+		if str == "" {
+			res.Skipped++
+			continue
+		}
 		s := strings.SplitAfter(str, "when=")
 		t, err := strconv.ParseInt(s[1], 10, 64)
 		if err != nil {
-			return times, err
+			return err
 		}
 		tm := time.Unix(t, 0)
 		date := tm.UTC()
-		if times.Earliest.IsZero() || date.Before(times.Earliest) {
-			times.Earliest = date
-		}
-		if times.Latest.IsZero() || date.After(times.Latest) {
-			times.Latest = date
-		}
+		res.Add(date)
 	}
-	return times, nil
+	return nil
 }
 
-func process_bluecoat(filename string) (times tf_time.Times, err error) {
-	var reader io.Reader
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-	defer f.Close()
-
-	if strings.Contains(filename, ".gz") {
-		gf, err := gzip.NewReader(f)
-		if err != nil {
-			f.Seek(0, 0)
-			reader = f
-		} else {
-			reader = gf
-			defer gf.Close()
-		}
-	} else {
-		reader = f
-	}
-
+func process_bluecoat(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for i := 0; i < 6; i++ {
 		if scanner.Scan() {
+			res.Skipped++
 		}
 	}
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, err := regexp.Compile("[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 		if err != nil {
-			return times, err
+			return err
 		}
 		str := r.FindString(line)
-		tm, err := time.Parse("2006-01-02 15:04:05", str)
+		tm, err := res.Parse("2006-01-02 15:04:05", str)
 		if err != nil {
-			return times, err
-		}
-		if times.Earliest.IsZero() || tm.Before(times.Earliest) {
-			times.Earliest = tm
-		}
-		if times.Latest.IsZero() || tm.After(times.Latest) {
-			times.Latest = tm
+			return err
 		}
+		res.Add(tm)
 	}
 
-	return times, err
+	return scanner.Err()
 }
 
-func process_codevision(filename string) (times tf_time.Times, err error) {
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_codevision(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, err := regexp.Compile("timestamp=[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2}T[0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}-[0-9]{1,2}:[0-9]{1,2}")
 		if err != nil {
-			return times, err
+			return err
 		}
 		str := r.FindString(line)
+		if str == "" {
+			res.Skipped++
+			continue
+		}
 		s := strings.SplitAfter(str, "timestamp=")
-		t, err := time.Parse("2006-01-02T15:04:05-07:00", s[1])
+		t, err := res.Parse("2006-01-02T15:04:05-07:00", s[1])
 		tm := t.UTC()
 		if err != nil {
-			return times, err
+			return err
 		}
 
-		if times.Earliest.IsZero() || tm.Before(times.Earliest) {
-			times.Earliest = tm
-		}
-		if times.Latest.IsZero() || tm.After(times.Latest) {
-			times.Latest = tm
-		}
+		res.Add(tm)
 
 	}
 
-	return times, nil
+	return nil
 }
 
-func process_cer(filename string) (times tf_time.Times, err error) {
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_cer(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, err := regexp.Compile("received=\"[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}.[0-9]{1,6}-[0-9]{1,2}:[0-9]{1,2}")
 		if err != nil {
-			return times, nil
+			return nil
 		}
 		str := r.FindString(line)
-		split := strings.SplitAfter(str, "received=\"")
-		t, err := time.Parse("2006-01-02 15:04:05.000000-07:00", split[1])
-		tm := t.UTC()
-		if times.Earliest.IsZero() || tm.Before(times.Earliest) {
-			times.Earliest = tm
-		}
-		if times.Latest.IsZero() || tm.After(times.Latest) {
-			times.Latest = tm
+		if str == "" {
+			res.Skipped++
+			continue
 		}
+		split := strings.SplitAfter(str, "received=\"")
+		res.AddParsed(res.Parse("2006-01-02 15:04:05.000000-07:00", split[1]))
 	}
 
-	return times, nil
+	return nil
 }
 
-func process_sep(filename string) (times tf_time.Times, err error) {
-	var reader io.Reader
-	var str string
-	var s string
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_sep(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, err := regexp.Compile("Event time: [0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 		if err != nil {
-			return times, err
+			return err
 		}
 		y, err := regexp.Compile("Begin: [0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 		if err != nil {
-			return times, err
+			return err
 		}
-		str = r.FindString(line)
-		if str == "" {
-			str = y.FindString(line)
-			if str == "" {
-				x, _ := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,4} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
-				date := x.FindString(line)
-				if date != "" {
-					t, _ := time.Parse("Jan 2 2006 15:04:05", date)
-					if times.Earliest.IsZero() || t.Before(times.Earliest) {
-						times.Earliest = t
-					}
-					if times.Latest.IsZero() || t.After(times.Latest) {
-						times.Latest = t
-					}
-				} else {
-					s, err := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
-					if err != nil {
-						return times, err
-					}
-					str = s.FindString(line)
-					split := strings.SplitAfter(filename, ".")
-					x, _ := regexp.Compile("[0-9]{1,4}")
-					year := x.FindString(split[1])
-					join := []string{str, year}
-					temp := strings.Join(join, " ")
-					t, _ := time.Parse("Jan 2 15:04:05 2006", temp)
-					if times.Earliest.IsZero() || t.Before(times.Earliest) {
-						times.Earliest = t
-					}
-					if times.Latest.IsZero() || t.After(times.Latest) {
-						times.Latest = t
-					}
-				}
-			} else {
-				split := strings.Split(str, "Begin: ")
-				s = split[1]
-			}
-		} else {
-			split := strings.Split(str, "Event time: ")
-			s = split[1]
+		x, _ := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,4} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
+		s, err := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
+		if err != nil {
+			return err
 		}
-		if str != "" {
-			t, _ := time.Parse("2006-01-02 15:04:05", s)
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
+
+		if str := r.FindString(line); str != "" {
+			split := strings.Split(str, "Event time: ")
+			res.AddParsed(res.Parse("2006-01-02 15:04:05", split[1]))
+		} else if str := y.FindString(line); str != "" {
+			split := strings.Split(str, "Begin: ")
+			res.AddParsed(res.Parse("2006-01-02 15:04:05", split[1]))
+		} else if date := x.FindString(line); date != "" {
+			res.AddParsed(res.Parse("Jan 2 2006 15:04:05", date))
+		} else if str := s.FindString(line); str != "" {
+			// No year in the line, so take it from the filename.
+			split := strings.SplitAfter(filename, ".")
+			year := ""
+			if len(split) > 1 {
+				year = textYear.FindString(split[1])
 			}
+			join := []string{str, year}
+			temp := strings.Join(join, " ")
+			res.AddParsed(res.Parse("Jan 2 15:04:05 2006", temp))
+		} else {
+			res.Skipped++
 		}
 	}
 
-	return times, nil
+	return nil
 }
 
-func process_juniper(filename string) (times tf_time.Times, err error) {
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_juniper(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, _ := regexp.Compile("[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 		str := r.FindString(line)
 		if str != "" {
-			t, _ := time.Parse("2006-01-02 15:04:05", str)
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
-			}
+			res.AddParsed(res.Parse("2006-01-02 15:04:05", str))
 		} else {
 			y, _ := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,4} [0-9[{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 			s := y.FindString(line)
 			if s != "" {
-				t, _ := time.Parse("Jan 2 2006 15:04:05", s)
-				if times.Earliest.IsZero() || t.Before(times.Earliest) {
-					times.Earliest = t
-				}
-				if times.Latest.IsZero() || t.After(times.Latest) {
-					times.Latest = t
-				}
+				res.AddParsed(res.Parse("Jan 2 2006 15:04:05", s))
 			} else {
 				m, err := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 				if err != nil {
-					return times, err
+					return err
 				}
 				str = m.FindString(line)
+				if str == "" {
+					res.Skipped++
+					continue
+				}
 				split := strings.SplitAfter(filename, ".")
 				x, _ := regexp.Compile("[0-9]{1,4}")
 				year := x.FindString(split[1])
 				join := []string{str, year}
 				temp := strings.Join(join, " ")
-				t, _ := time.Parse("Jan 2 15:04:05 2006", temp)
-				if times.Earliest.IsZero() || t.Before(times.Earliest) {
-					times.Earliest = t
-				}
-				if times.Latest.IsZero() || t.After(times.Latest) {
-					times.Latest = t
-				}
+				res.AddParsed(res.Parse("Jan 2 15:04:05 2006", temp))
 			}
 		}
 	}
-	return times, nil
+	return nil
 }
 
-func process_email(filename string) (times tf_time.Times, err error) {
-
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_email(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, err := regexp.Compile("DATETIME][0-9]{1,4}.[0-9]{1,2}.[0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}.[0-9]{1,6}")
 		if err != nil {
-			return times, err
+			return err
 		}
 		str := r.FindString(line)
 		if str != "" {
 			date := strings.SplitAfter(str, "DATETIME]")
-			t, _ := time.Parse("2006.01.02 15:04:05.000000", date[1])
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
-			}
+			res.AddParsed(res.Parse("2006.01.02 15:04:05.000000", date[1]))
+		} else {
+			res.Skipped++
 		}
 	}
-	return times, nil
+	return nil
 }
 
-func process_text(filename string) (times tf_time.Times, err error) {
-
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
+var (
+	textFullTime  = regexp.MustCompile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,4} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
+	textShortTime = regexp.MustCompile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
+	textYear      = regexp.MustCompile("[0-9]{1,4}")
+)
 
+func process_text(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		line := scanner.Text()
-		r, err := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,4} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
-		if err != nil {
-			return times, err
-		}
-		str := r.FindString(line)
-		//log.Printf("%s\n", str)
-		if str != "" {
-			t, _ := time.Parse("Jan 2 2006 15:04:05", str)
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
-			}
-		} else {
-			s, err := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
-			if err != nil {
-				return times, err
-			}
-			str = s.FindString(line)
-			split := strings.SplitAfter(filename, ".")
-			x, _ := regexp.Compile("[0-9]{1,4}")
-			year := x.FindString(split[1])
-			join := []string{str, year}
-			temp := strings.Join(join, " ")
-			t, _ := time.Parse("Jan 2 15:04:05 2006", temp)
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
-			}
+		t, err := textTime(scanner.Text(), filename, res.Location())
+		if err == errNoTime {
+			res.Skipped++
+			continue
 		}
+		res.AddParsed(t, err)
 	}
-	return times, nil
+	return nil
 }
 
-func process_snare(filename string) (times tf_time.Times, err error) {
-
-	var reader io.Reader
+// errNoTime is returned for a line that has no time in it at all, which
+// counts as Skipped rather than Failed.
+var errNoTime = errors.New("no time in line")
 
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
+// textTime returns the time of a line of a "text" file, in loc. Lines without
+// a year take it from the filename.
+func textTime(line string, filename string, loc *time.Location) (time.Time, error) {
+	str := textFullTime.FindString(line)
+	//log.Printf("%s\n", str)
+	if str != "" {
+		return time.ParseInLocation("Jan 2 2006 15:04:05", str, loc)
 	}
 
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
+	str = textShortTime.FindString(line)
+	if str == "" {
+		return time.Time{}, errNoTime
+	}
+	split := strings.SplitAfter(filename, ".")
+	if len(split) < 2 {
+		return time.Time{}, fmt.Errorf("no year in line or filename %s", filename)
 	}
+	year := textYear.FindString(split[1])
+	join := []string{str, year}
+	temp := strings.Join(join, " ")
+	return time.ParseInLocation("Jan 2 15:04:05 2006", temp, loc)
+}
 
+func process_snare(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		y, _ := regexp.Compile("[A-Za-z]{1,3} [A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2} [0-9]{1,4}")
 		s := y.FindString(line)
 		if s != "" {
-			t, _ := time.Parse("Mon Jan 02 15:04:05 2006", s)
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
-			}
+			res.AddParsed(res.Parse("Mon Jan 02 15:04:05 2006", s))
 		} else {
 			r, _ := regexp.Compile("[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2}T[0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}-[0-9]{1,4}")
 			str := r.FindString(line)
 			if str != "" {
-				t, _ := time.Parse("2006-01-02T15:04:05-0700", str)
-				tm := t.UTC()
-				if times.Earliest.IsZero() || tm.Before(times.Earliest) {
-					times.Earliest = tm
-				}
-				if times.Latest.IsZero() || tm.After(times.Latest) {
-					times.Latest = tm
-				}
+				res.AddParsed(res.Parse("2006-01-02T15:04:05-0700", str))
+			} else {
+				res.Skipped++
 			}
 		}
 	}
-	return times, nil
+	return nil
 }
 
-func process_iod(filename string) (times tf_time.Times, err error) {
-
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_iod(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, _ := regexp.Compile("[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2}T[0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}-[0-9]{1,4}")
 		str := r.FindString(line)
 		if str != "" {
-			t, _ := time.Parse("2006-01-02T15:04:05-0700", str)
-			tm := t.UTC()
-			if times.Earliest.IsZero() || tm.Before(times.Earliest) {
-				times.Earliest = tm
-			}
-			if times.Latest.IsZero() || tm.After(times.Latest) {
-				times.Latest = tm
-			}
+			res.AddParsed(res.Parse("2006-01-02T15:04:05-0700", str))
+		} else {
+			res.Skipped++
 		}
 	}
-	return times, nil
+	return nil
 }
 
-func process_win_messages(filename string) (times tf_time.Times, err error) {
-
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_win_messages(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, _ := regexp.Compile("[A-Za-z]{1,3} [A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2} [0-9]{1,4}")
 		str := r.FindString(line)
 		if str != "" {
-			t, _ := time.Parse("Mon Jan 2 15:04:05 2006", str)
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
-			}
+			res.AddParsed(res.Parse("Mon Jan 2 15:04:05 2006", str))
 		} else {
 			x, _ := regexp.Compile("[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2}T[0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}-[0-9]{1,2}:[0-9]{1,2}")
 			s := x.FindString(line)
-			t, _ := time.Parse("2006-01-02T15:04:05-07:00", s)
-			tm := t.UTC()
-			if times.Earliest.IsZero() || tm.Before(times.Earliest) {
-				times.Earliest = tm
-			}
-			if times.Latest.IsZero() || tm.After(times.Latest) {
-				times.Latest = tm
+			if s == "" {
+				res.Skipped++
+				continue
 			}
+			res.AddParsed(res.Parse("2006-01-02T15:04:05-07:00", s))
 		}
 	}
-	return times, nil
+	return nil
 }
 
-func process_wireless(filename string) (times tf_time.Times, err error) {
-
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_wireless(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
-	j := 1
 	for scanner.Scan() {
 		line := scanner.Text()
 		y, _ := regexp.Compile("Time=[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2}T[0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 		s := y.FindString(line)
 		if s != "" {
 			split := strings.SplitAfter(s, "Time=")
-			t, _ := time.Parse("2006-01-02T15:04:05", split[1])
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
-			}
+			res.AddParsed(res.Parse("2006-01-02T15:04:05", split[1]))
 		} else {
 			r, _ := regexp.Compile("[A-Za-z]{1,3} [0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2} [0-9]{1,4}")
 			str := r.FindString(line)
 			if str != "" {
-				t, _ := time.Parse("Jan 2 15:04:05 2006", str)
-				if times.Earliest.IsZero() || t.Before(times.Earliest) {
-					times.Earliest = t
-				}
-				if times.Latest.IsZero() || t.After(times.Latest) {
-					times.Latest = t
-				}
+				res.AddParsed(res.Parse("Jan 2 15:04:05 2006", str))
 			} else {
 				tm, err := regexp.Compile("[A-Za-z]{1,3} *[0-9]{1,2} [0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 				if err != nil {
-					return times, err
+					return err
 				}
 				date := tm.FindString(line)
+				if date == "" {
+					res.Skipped++
+					continue
+				}
 				split := strings.SplitAfter(filename, ".")
 				x, _ := regexp.Compile("[0-9]{1,4}")
 				year := x.FindString(split[1])
 				if year != "" {
 					join := []string{date, year}
 					temp := strings.Join(join, " ")
-					t, _ := time.Parse("Jan 2 15:04:05 2006", temp)
-					if times.Earliest.IsZero() || t.Before(times.Earliest) {
-						times.Earliest = t
-						log.Println(j, "   ", t)
-					}
-					if times.Latest.IsZero() || t.After(times.Latest) {
-						times.Latest = t
-					}
+					res.AddParsed(res.Parse("Jan 2 15:04:05 2006", temp))
+				} else {
+					res.Failed++
 				}
 			}
 		}
-		j += 1
 	}
-	return times, nil
+	return nil
 }
 
-func process_stealthwatch(filename string) (times tf_time.Times, err error) {
-
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-
-	gf, err := gzip.NewReader(f)
-	if err != nil {
-		f.Seek(0, 0)
-		reader = f
-	} else {
-		reader = gf
-		defer gf.Close()
-	}
-
+func process_stealthwatch(reader io.Reader, filename string, res *Result) error {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		line := scanner.Text()
 		r, _ := regexp.Compile("[0-9]{1,4}-[0-9]{1,2}-[0-9]{1,2}T[0-9]{1,2}:[0-9]{1,2}:[0-9]{1,2}")
 		str := r.FindString(line)
 		if str != "" {
-			t, _ := time.Parse("2006-01-02T15:04:05", str)
-			if times.Earliest.IsZero() || t.Before(times.Earliest) {
-				times.Earliest = t
-			}
-			if times.Latest.IsZero() || t.After(times.Latest) {
-				times.Latest = t
-			}
-		}
-
-	}
-	return times, nil
-}
-
-func process_pcap(filename string) (times tf_time.Times, err error) {
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-	defer f.Close()
-
-	if strings.Contains(filename, ".gz") {
-		// handle gzip
-		gf, err := gzip.NewReader(f)
-		if err != nil {
-			f.Seek(0, 0)
-			reader = f
+			res.AddParsed(res.Parse("2006-01-02T15:04:05", str))
 		} else {
-			reader = gf
-			defer gf.Close()
+			res.Skipped++
 		}
-	} else if strings.Contains(filename, ".xz") {
-		// handle xz
-		xf, err := xz.NewReader(f, 0)
-		if err != nil {
-			log.Printf("error reading .xz file = %s, skipping...\n", err)
-			return times, err
-		} else {
-			reader = xf
-			// XXX xz has no xz.Close()
-		}
-	} else {
-		// just a plain, raw .pcap file
-		reader = f
 	}
+	return nil
+}
 
+func process_pcap(reader io.Reader, filename string, res *Result) error {
 	// start reading pcap
 	pf, err := pcap.NewReader(reader)
 	if err != nil {
-		return times, err
+		return err
 	}
 
+	// The pcap file header is 24 bytes, and every frame has a 16 byte
+	// header of its own.
+	offset := int64(24)
 	for {
 		ff, _ := pf.ReadFrame()
 		if ff == nil {
@@ -788,15 +486,11 @@ func process_pcap(filename string) (times tf_time.Times, err error) {
 
 		when := ff.Time()
 		t := when.UTC()
-		if times.Earliest.IsZero() || t.Before(times.Earliest) {
-			times.Earliest = t
-		}
-		if times.Latest.IsZero() || t.After(times.Latest) {
-			times.Latest = t
-		}
+		res.AddAt(t, offset)
+		offset += 16 + int64(ff.Header.Caplen)
 	}
 
-	return times, nil
+	return nil
 }
 
 // Process an FSDB-formatted file. Currently we manually process the format of
@@ -824,79 +518,53 @@ func process_pcap(filename string) (times tf_time.Times, err error) {
 
 */
 
-func process_fsdb_time_col_1(filename string) (times tf_time.Times, err error) {
-	return process_fsdb(filename, 1)
+func process_fsdb_time_col_1(reader io.Reader, filename string, res *Result) error {
+	return process_fsdb(reader, 1, res)
 }
 
-func process_fsdb_time_col_2(filename string) (times tf_time.Times, err error) {
-	return process_fsdb(filename, 2)
+func process_fsdb_time_col_2(reader io.Reader, filename string, res *Result) error {
+	return process_fsdb(reader, 2, res)
 }
 
-func process_fsdb(filename string, col int) (times tf_time.Times, err error) {
-
-	var reader io.Reader
-
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-	defer f.Close()
-
-	if strings.Contains(filename, ".gz") {
-		// handle gzip
-		gf, err := gzip.NewReader(f)
-		if err != nil {
-			f.Seek(0, 0)
-			reader = f
-		} else {
-			reader = gf
-			defer gf.Close()
-		}
-	} else if strings.Contains(filename, ".xz") {
-		// handle xz
-		xf, err := xz.NewReader(f, 0)
-		if err != nil {
-			log.Printf("error reading .xz file = %s, skipping...\n", err)
-			return times, err
-		} else {
-			reader = xf
-			// XXX xz has no xz.Close()
-		}
-	} else {
-		// just a plain .fsdb file
-		reader = f
-	}
-
+func process_fsdb(reader io.Reader, col int, res *Result) error {
 	// now process files
-	scanner := bufio.NewScanner(reader)
+	scanner := newLineScanner(reader)
 	for scanner.Scan() {
 		// read line
 		line := scanner.Text()
 
 		if strings.HasPrefix(line, "#") {
 			// if a comment or header, continue
+			res.Skipped++
 			continue
 		}
 
-		// only want the column # "col"
-		ts := strings.SplitN(line, "\t", col+1)[col-1]
-
-		// convert unixtimestamp into golang time
-		// accepts both second and nanosecond precision
-		tm, err := tf_time.UnmarshalTime([]byte(ts))
+		tm, err := fsdbTime(line, col)
 		if err != nil {
-			return times, err
+			return err
 		}
 
-		if times.Earliest.IsZero() || tm.Before(times.Earliest) {
-			times.Earliest = tm
-		}
-		if times.Latest.IsZero() || tm.After(times.Latest) {
-			times.Latest = tm
-		}
+		res.AddAt(tm, scanner.Offset())
 	}
 
-	return times, err
+	return scanner.Err()
+}
+
+// fsdbTime returns the time in column col of an FSDB data line.
+func fsdbTime(line string, col int) (time.Time, error) {
+	if strings.HasPrefix(line, "#") {
+		return time.Time{}, fmt.Errorf("no time in comment line")
+	}
+
+	// only want the column # "col"
+	cols := strings.SplitN(line, "\t", col+1)
+	if len(cols) < col {
+		return time.Time{}, fmt.Errorf("no column %d in line", col)
+	}
+
+	// convert unixtimestamp into golang time
+	// accepts both second and nanosecond precision
+	return tf_time.UnmarshalTime([]byte(cols[col-1]))
 }
 
 // http://www.ietf.org/rfc/rfc3164.txt
@@ -924,27 +592,15 @@ func process_fsdb(filename string, col int) (times tf_time.Times, err error) {
      inclusive.  The minute (mm) and second (ss) entries are between
      00 and 59 inclusive.
 */
-func process_syslog_rfc3164(filename string) (times tf_time.Times, err error) {
-	// TODO add a parameter to specify year and timezone
+func process_syslog_rfc3164(reader io.Reader, filename string, res *Result) error {
+	// TODO add a parameter to specify the year; the timezone is the
+	// source's (see Result.Parse)
 	//
 	// XXX year := 0000
-	// XXX tz := Zulu
 
 	// 012345678901234
 	// Mmm dd hh:mm:ss
 
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-	defer f.Close()
-
-	reader, err := OpenFile(f)
-	if err != nil {
-		log.Printf("error is getting an io.Reader: %s", err)
-		return times, err
-	}
-
 	// now process files
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
@@ -954,107 +610,125 @@ func process_syslog_rfc3164(filename string) (times tf_time.Times, err error) {
 
 		// from "time":
 		//   Stamp      = "Jan _2 15:04:05"
-		t, err := time.Parse(time.Stamp, ts)
+		t, err := res.Parse(time.Stamp, ts)
 		if err != nil {
-			return times, err
+			return err
 		}
 
-		if times.Earliest.IsZero() || t.Before(times.Earliest) {
-			times.Earliest = t
-		}
-		if times.Latest.IsZero() || t.After(times.Latest) {
-			times.Latest = t
-		}
+		res.Add(t)
 	}
-	if err = scanner.Err(); err != nil {
-		log.Printf("reading input: ", err)
+	err := scanner.Err()
+	if err != nil {
+		log.Printf("reading input: %s", err)
 	}
 
-	return times, err
+	return err
 }
 
-func process_mrt(filename string) (times tf_time.Times, err error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return times, err
-	}
-	defer f.Close()
+// MRT record types from RFC 6396, section 4. Types 0-10 are deprecated but
+// may still show up in old archives. The _ET types carry an extra 4-byte
+// microsecond timestamp at the start of the message, counted in the length.
+const (
+	mrtHeaderLen     = 12
+	mrtMaxRecordLen  = 1 << 24
+	mrtTypeBGP4MP_ET = 17
+	mrtTypeISIS_ET   = 33
+	mrtTypeOSPFv3_ET = 49
+)
 
-	reader, err := OpenFile(f)
-	if err != nil {
-		log.Printf("error is getting an io.Reader: %s", err)
-		return times, err
-	}
+var mrtTypes = map[uint16]bool{
+	0: true, 1: true, 2: true, 3: true, 4: true, 5: true, 6: true, 7: true,
+	8: true, 9: true, 10: true, 11: true, 12: true, 13: true,
+	16: true, 17: true, 32: true, 33: true, 48: true, 49: true,
+}
 
-	mrtReader := mrt.NewReader(reader)
+// Records claiming to be from further in the future than this are rejected.
+const mrtMaxClockSkew = 24 * time.Hour
 
-	// protect ourselves from panic (due to corrupt MRT files)
-	defer func() {
-		//log.Printf("[mrt] done processing")
-		if x := recover(); x != nil {
-			log.Printf("[mrt] runtime panic: %v, processing ended early on filename: %s",
-				x, filename)
+func process_mrt(reader io.Reader, filename string, res *Result) error {
+	return read_mrt(bufio.NewReader(reader), filename, res)
+}
+
+// read_mrt walks the MRT common headers in r. We only need the timestamps, so
+// the message bodies are skipped rather than decoded.
+func read_mrt(r io.Reader, filename string, res *Result) error {
+	var offset int64
+	records, rejected := 0, 0
+	header := make([]byte, mrtHeaderLen)
+	usec := make([]byte, 4)
+	horizon := time.Now().Add(mrtMaxClockSkew)
+
+	corrupt := func(err error) error {
+		return &CorruptFileError{
+			Filename: filename,
+			Records:  records,
+			Offset:   offset,
+			Err:      err,
+		}
+	}
+	// Errors from reader (e.g., a bad gzip checksum) mean the file is
+	// damaged too, just not in a way that MRT can tell.
+	readErr := func(err error) error {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return corrupt(ErrTruncated)
 		}
-	}()
+		return corrupt(err)
+	}
 
 	for {
-		// grab next record
-		record, err := mrtReader.Next()
-
-		if record == nil {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
 			break
+		} else if err != nil {
+			return readErr(err)
 		}
-		if err != nil {
-			log.Printf("[mrt] error: %s, continuing...\n", err)
-			continue
+
+		sec := binary.BigEndian.Uint32(header[0:4])
+		typ := binary.BigEndian.Uint16(header[4:6])
+		length := binary.BigEndian.Uint32(header[8:12])
+
+		if !mrtTypes[typ] || length > mrtMaxRecordLen {
+			return corrupt(ErrCorrupt)
 		}
 
-		// only care about timestamp
-		// TODO check if valid timestamp?
-		t := (*record).Timestamp()
+		t := time.Unix(int64(sec), 0).UTC()
+		body := int64(length)
 
-		if times.Earliest.IsZero() || t.Before(times.Earliest) {
-			times.Earliest = t
+		switch typ {
+		case mrtTypeBGP4MP_ET, mrtTypeISIS_ET, mrtTypeOSPFv3_ET:
+			if length < 4 {
+				return corrupt(ErrCorrupt)
+			}
+			if _, err := io.ReadFull(r, usec); err != nil {
+				return readErr(err)
+			}
+			us := binary.BigEndian.Uint32(usec)
+			if us >= 1000000 {
+				return corrupt(ErrCorrupt)
+			}
+			t = t.Add(time.Duration(us) * time.Microsecond)
+			body -= 4
 		}
-		if times.Latest.IsZero() || t.After(times.Latest) {
-			times.Latest = t
+
+		if _, err := io.CopyN(io.Discard, r, body); err != nil {
+			return readErr(err)
 		}
-	}
 
-	// does this return after a panic?
-	return times, err
-}
+		offset += mrtHeaderLen + int64(length)
+		records++
 
-func OpenFile(f *os.File) (reader io.Reader, err error) {
-	filename := f.Name()
-	if strings.HasSuffix(filename, ".gz") {
-		// handle gzip
-		gf, err := gzip.NewReader(f)
-		if err != nil {
-			f.Seek(0, 0)
-			reader = f
-		} else {
-			reader = gf
-			defer gf.Close()
-		}
-	} else if strings.HasSuffix(filename, ".bz2") {
-		// handle bz2 -- no bzip2.Close() or error return...
-		bf := bzip2.NewReader(f)
-		reader = bf
-	} else if strings.HasSuffix(filename, ".xz") {
-		// handle xz
-		xf, err := xz.NewReader(f, 0)
-		if err != nil {
-			log.Printf("error reading .xz file = %s, skipping...\n", err)
-			return reader, err
-		} else {
-			reader = xf
-			// XXX xz has no xz.Close()
+		if sec == 0 || t.After(horizon) {
+			rejected++
+			res.Failed++
+			continue
 		}
-	} else {
-		// just a plain file
-		reader = f
+
+		res.Add(t)
+	}
+
+	if rejected > 0 {
+		log.Printf("[mrt] rejected %d of %d records with implausible timestamps in %s",
+			rejected, records, filename)
 	}
 
-	return reader, nil
+	return nil
 }
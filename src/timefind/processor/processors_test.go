@@ -0,0 +1,213 @@
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mrtRecord returns an MRT record of type typ with a body of n bytes. For the
+// _ET types, usec is put at the start of the body.
+func mrtRecord(sec uint32, typ uint16, usec uint32, n int) []byte {
+	rec := make([]byte, mrtHeaderLen+n)
+	binary.BigEndian.PutUint32(rec[0:], sec)
+	binary.BigEndian.PutUint16(rec[4:], typ)
+	binary.BigEndian.PutUint32(rec[8:], uint32(n))
+	if typ == mrtTypeBGP4MP_ET && n >= 4 {
+		binary.BigEndian.PutUint32(rec[12:], usec)
+	}
+	return rec
+}
+
+func join(recs ...[]byte) []byte {
+	return bytes.Join(recs, nil)
+}
+
+func TestReadMRT(t *testing.T) {
+	const bgp4mp = 16
+	future := uint32(time.Now().Add(48 * time.Hour).Unix())
+	soon := uint32(time.Now().Add(time.Hour).Unix())
+
+	tests := []struct {
+		name     string
+		data     []byte
+		earliest time.Time
+		latest   time.Time
+		parsed   int64
+		failed   int64
+		err      error // nil, ErrTruncated or ErrCorrupt
+	}{
+		{"empty", nil, time.Time{}, time.Time{}, 0, 0, nil},
+		{"BGP4MP",
+			join(mrtRecord(1436000000, bgp4mp, 0, 20), mrtRecord(1436000100, bgp4mp, 0, 0)),
+			time.Unix(1436000000, 0), time.Unix(1436000100, 0), 2, 0, nil},
+		{"BGP4MP_ET microseconds",
+			join(mrtRecord(1436000000, mrtTypeBGP4MP_ET, 250000, 20)),
+			time.Unix(1436000000, 250000000), time.Unix(1436000000, 250000000), 1, 0, nil},
+		{"sec 0",
+			join(mrtRecord(0, bgp4mp, 0, 8), mrtRecord(1436000000, bgp4mp, 0, 8)),
+			time.Unix(1436000000, 0), time.Unix(1436000000, 0), 1, 1, nil},
+		{"past the horizon",
+			join(mrtRecord(1436000000, bgp4mp, 0, 8), mrtRecord(future, bgp4mp, 0, 8)),
+			time.Unix(1436000000, 0), time.Unix(1436000000, 0), 1, 1, nil},
+		{"within the horizon",
+			join(mrtRecord(soon, bgp4mp, 0, 8)),
+			time.Unix(int64(soon), 0), time.Unix(int64(soon), 0), 1, 0, nil},
+		{"truncated header",
+			join(mrtRecord(1436000000, bgp4mp, 0, 8), mrtRecord(1436000100, bgp4mp, 0, 8)[:5]),
+			time.Time{}, time.Time{}, 0, 0, ErrTruncated},
+		{"truncated body",
+			mrtRecord(1436000000, bgp4mp, 0, 20)[:20],
+			time.Time{}, time.Time{}, 0, 0, ErrTruncated},
+		{"truncated microseconds",
+			mrtRecord(1436000000, mrtTypeBGP4MP_ET, 0, 20)[:14],
+			time.Time{}, time.Time{}, 0, 0, ErrTruncated},
+		{"bad microseconds",
+			mrtRecord(1436000000, mrtTypeBGP4MP_ET, 1000000, 20),
+			time.Time{}, time.Time{}, 0, 0, ErrCorrupt},
+		{"ET too short",
+			mrtRecord(1436000000, mrtTypeBGP4MP_ET, 0, 2),
+			time.Time{}, time.Time{}, 0, 0, ErrCorrupt},
+		{"unknown type",
+			mrtRecord(1436000000, 99, 0, 0),
+			time.Time{}, time.Time{}, 0, 0, ErrCorrupt},
+	}
+
+	for _, test := range tests {
+		res := Result{}
+		err := read_mrt(bytes.NewReader(test.data), "test.mrt", &res)
+		if test.err != nil {
+			cerr, ok := err.(*CorruptFileError)
+			if !ok || cerr.Err != test.err {
+				t.Errorf("%s: got error %v, expected %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !res.Period.Earliest.Equal(test.earliest) || !res.Period.Latest.Equal(test.latest) {
+			t.Errorf("%s: got period %v, expected %s to %s",
+				test.name, res.Period, test.earliest, test.latest)
+		}
+		if res.Parsed != test.parsed || res.Failed != test.failed {
+			t.Errorf("%s: got %d parsed and %d failed, expected %d and %d",
+				test.name, res.Parsed, res.Failed, test.parsed, test.failed)
+		}
+	}
+
+	// A record longer than any MRT record is corrupt, not a reason to
+	// skip 4 GiB.
+	rec := mrtRecord(1436000000, bgp4mp, 0, 0)
+	binary.BigEndian.PutUint32(rec[8:], mrtMaxRecordLen+1)
+	if err := read_mrt(bytes.NewReader(rec), "test.mrt", &Result{}); err == nil {
+		t.Errorf("too long: expected an error")
+	}
+}
+
+type errReader struct {
+	data []byte
+	err  error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReadMRTDecompressionErrors(t *testing.T) {
+	data := join(mrtRecord(1436000000, 16, 0, 20), mrtRecord(1436000100, 16, 0, 20))
+
+	// Errors reading the data, after some records, make the file corrupt
+	// rather than stopping the indexer.
+	bad := errors.New("flate: corrupt input")
+	err := read_mrt(&errReader{data[:40], bad}, "test.mrt.gz", &Result{})
+	if cerr, ok := err.(*CorruptFileError); !ok || cerr.Err != bad || cerr.Records != 1 {
+		t.Errorf("got %v, expected a CorruptFileError for %s after 1 record", err, bad)
+	}
+
+	// A gzip file with a bad checksum.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	gz := buf.Bytes()
+	gz[len(gz)-8] ^= 0xff
+	zr, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = read_mrt(zr, "test.mrt.gz", &Result{})
+	if cerr, ok := err.(*CorruptFileError); !ok || cerr.Err != gzip.ErrChecksum {
+		t.Errorf("bad checksum: got %v, expected a CorruptFileError", err)
+	}
+
+	// And one cut short.
+	zr, err = gzip.NewReader(bytes.NewReader(buf.Bytes()[:len(gz)/2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = read_mrt(zr, "test.mrt.gz", &Result{})
+	if _, ok := err.(*CorruptFileError); !ok {
+		t.Errorf("truncated gzip: got %v, expected a CorruptFileError", err)
+	}
+}
+
+func TestProcessorStats(t *testing.T) {
+	tests := []struct {
+		typ      string
+		filename string
+		data     string
+		stats    Stats
+	}{
+		{"text", "messages.2015", "Jan 2 2015 10:00:00 full\nJan 3 10:00:00 short\nno time here\n" +
+			"Feb 31 2015 10:00:00 bad date\n", Stats{Parsed: 2, Skipped: 1, Failed: 1}},
+		// A line with a time but no year to go with it can't be parsed.
+		{"text", "messages", "Jan 3 10:00:00 short\n\n", Stats{Skipped: 1, Failed: 1}},
+		{"email", "mail.log", "[DATETIME]2015.01.02 10:00:00.000000 sent\nheader\n",
+			Stats{Parsed: 1, Skipped: 1}},
+		{"sep", "sep.2015", "Event time: 2015-01-02 10:00:00\nBegin: 2015-01-02 11:00:00\n" +
+			"Jan 2 2015 12:00:00\nJan 2 13:00:00\nnothing\n", Stats{Parsed: 4, Skipped: 1}},
+		{"juniper", "juniper.2015", "2015-01-02 10:00:00\nJan 2 11:00:00\nnothing\n",
+			Stats{Parsed: 2, Skipped: 1}},
+		{"snare", "snare.log", "Fri Jan 02 10:00:00 2015\n2015-01-02T10:00:00-0800\nnothing\n",
+			Stats{Parsed: 2, Skipped: 1}},
+		{"iod", "iod.log", "2015-01-02T10:00:00-0800\nnothing\n", Stats{Parsed: 1, Skipped: 1}},
+		{"win_messages", "win.log", "Fri Jan 2 10:00:00 2015\n2015-01-02T10:00:00-08:00\nnothing\n",
+			Stats{Parsed: 2, Skipped: 1}},
+		{"wireless", "wireless.2015", "Time=2015-01-02T10:00:00\nJan 2 10:00:00 2015\n" +
+			"Jan 2 11:00:00\nnothing\n", Stats{Parsed: 3, Skipped: 1}},
+		{"wireless", "wireless.log", "Jan 2 11:00:00\n", Stats{Failed: 1}},
+		{"cer", "cer.log", "received=\"2015-01-02 10:00:00.000000-08:00\"\nnothing\n",
+			Stats{Parsed: 1, Skipped: 1}},
+		{"codevision", "cv.log", "timestamp=2015-01-02T10:00:00-08:00\nnothing\n",
+			Stats{Parsed: 1, Skipped: 1}},
+		{"bomgar", "bomgar.log", "when=1420192800\nnothing\n", Stats{Parsed: 1, Skipped: 1}},
+		{"fsdb_time_col_1", "a.fsdb", "#fsdb -F t time\n1420192800\t1\n# | comment\n",
+			Stats{Parsed: 1, Skipped: 2}},
+	}
+
+	for _, test := range tests {
+		p, ok := Lookup(test.typ)
+		if !ok {
+			t.Fatalf("no %s processor", test.typ)
+		}
+		var res Result
+		if err := p.Process(strings.NewReader(test.data), test.filename, nil, &res); err != nil {
+			t.Errorf("%s %s: %s", test.typ, test.filename, err)
+			continue
+		}
+		if res.Stats != test.stats {
+			t.Errorf("%s %s: got %+v, want %+v", test.typ, test.filename, res.Stats, test.stats)
+		}
+	}
+}
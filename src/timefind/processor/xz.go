@@ -0,0 +1,223 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"xi2.org/x/xz"
+)
+
+/*
+  An xz file is one or more streams, each made of blocks that are compressed
+  independently of each other (xz --block-size, or xz -T, makes more than
+  one), and an index at the end of the stream listing the size of every
+  block. openXZAt uses the index to start decompressing at the block that an
+  offset falls in, rather than at the start of the file.
+
+  The decoder only reads whole streams, so openXZAt hands it one made up of
+  the original stream header, the blocks from there to the end of the
+  stream, and an index and footer for just those blocks, followed by the
+  rest of the file.
+*/
+
+const (
+	xzHeaderSize = 12
+	xzFooterSize = 12
+)
+
+var (
+	xzHeaderMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	xzFooterMagic = []byte{'Y', 'Z'}
+	errXZIndex    = errors.New("bad xz index")
+)
+
+// An xzBlock is a block of an xz stream, as listed in the stream's index.
+type xzBlock struct {
+	offset       int64 // In the file
+	unpadded     int64 // Compressed size, without the padding after it
+	uncompressed int64
+}
+
+// An xzStream is a stream in an xz file.
+type xzStream struct {
+	header []byte // The stream header
+	blocks []xzBlock
+	index  int64 // The offset of the index, just after the last block
+	end    int64 // The offset just after the stream footer
+}
+
+// xzStreams reads the index of every stream in the xz file r, of size bytes.
+func xzStreams(r io.ReaderAt, size int64) ([]xzStream, error) {
+	streams := []xzStream{}
+	pos := size
+	for pos > 0 {
+		// Streams may be followed by padding, in multiples of 4 bytes.
+		word := make([]byte, 4)
+		for pos >= 4 {
+			if _, err := r.ReadAt(word, pos-4); err != nil {
+				return nil, err
+			}
+			if binary.LittleEndian.Uint32(word) != 0 {
+				break
+			}
+			pos -= 4
+		}
+		if pos < xzHeaderSize+xzFooterSize {
+			return nil, errXZIndex
+		}
+
+		footer := make([]byte, xzFooterSize)
+		if _, err := r.ReadAt(footer, pos-xzFooterSize); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(footer[10:], xzFooterMagic) ||
+			crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer) {
+			return nil, errXZIndex
+		}
+		indexSize := (int64(binary.LittleEndian.Uint32(footer[4:])) + 1) * 4
+		indexStart := pos - xzFooterSize - indexSize
+		if indexStart < xzHeaderSize {
+			return nil, errXZIndex
+		}
+
+		index := make([]byte, indexSize)
+		if _, err := r.ReadAt(index, indexStart); err != nil {
+			return nil, err
+		}
+		blocks, err := parseXZIndex(index)
+		if err != nil {
+			return nil, err
+		}
+
+		// The blocks come just before the index, each padded to a
+		// multiple of 4 bytes.
+		start := indexStart
+		for i := len(blocks) - 1; i >= 0; i-- {
+			start -= (blocks[i].unpadded + 3) &^ 3
+			blocks[i].offset = start
+		}
+		start -= xzHeaderSize
+		if start < 0 {
+			return nil, errXZIndex
+		}
+
+		header := make([]byte, xzHeaderSize)
+		if _, err := r.ReadAt(header, start); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(header[:6], xzHeaderMagic) || !bytes.Equal(header[6:8], footer[8:10]) {
+			return nil, errXZIndex
+		}
+
+		streams = append([]xzStream{{header, blocks, indexStart, pos}}, streams...)
+		pos = start
+	}
+	return streams, nil
+}
+
+// parseXZIndex returns the blocks listed in an xz stream index.
+func parseXZIndex(index []byte) ([]xzBlock, error) {
+	n := len(index) - 4
+	if n < 1 || index[0] != 0 ||
+		crc32.ChecksumIEEE(index[:n]) != binary.LittleEndian.Uint32(index[n:]) {
+		return nil, errXZIndex
+	}
+
+	r := bytes.NewReader(index[1:n])
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(n) {
+		return nil, errXZIndex
+	}
+	blocks := make([]xzBlock, count)
+	for i := range blocks {
+		unpadded, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errXZIndex
+		}
+		uncompressed, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errXZIndex
+		}
+		blocks[i].unpadded = int64(unpadded)
+		blocks[i].uncompressed = int64(uncompressed)
+	}
+	return blocks, nil
+}
+
+// xzIndex returns the index and stream footer of a stream made of blocks.
+func xzIndex(blocks []xzBlock, flags []byte) []byte {
+	var buf bytes.Buffer
+	varint := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(x uint64) {
+		buf.Write(varint[:binary.PutUvarint(varint, x)])
+	}
+
+	buf.WriteByte(0)
+	putUvarint(uint64(len(blocks)))
+	for _, block := range blocks {
+		putUvarint(uint64(block.unpadded))
+		putUvarint(uint64(block.uncompressed))
+	}
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	footer := make([]byte, xzFooterSize)
+	binary.LittleEndian.PutUint32(footer[4:], uint32(buf.Len()/4-1))
+	copy(footer[8:], flags)
+	copy(footer[10:], xzFooterMagic)
+	binary.LittleEndian.PutUint32(footer, crc32.ChecksumIEEE(footer[4:10]))
+	buf.Write(footer)
+	return buf.Bytes()
+}
+
+// openXZAt decompresses the xz file f from the start of the block that
+// offset (in the decompressed data) falls in, and skips to offset. It
+// returns false if f has no block to start at other than its first, or its
+// index can't be read, so it may as well be read from the start.
+func openXZAt(f *os.File, offset int64) (io.Reader, bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	streams, err := xzStreams(f, info.Size())
+	if err != nil {
+		return nil, false, nil
+	}
+
+	// The last block starting at or before offset.
+	s, b := -1, -1
+	start, blockStart := int64(0), int64(0)
+	for i, stream := range streams {
+		for j, block := range stream.blocks {
+			if start <= offset {
+				s, b, blockStart = i, j, start
+			}
+			start += block.uncompressed
+		}
+	}
+	if s <= 0 && b <= 0 {
+		return nil, false, nil
+	}
+
+	stream := streams[s]
+	blocks := stream.blocks[b:]
+	reader, err := xz.NewReader(io.MultiReader(
+		bytes.NewReader(stream.header),
+		io.NewSectionReader(f, blocks[0].offset, stream.index-blocks[0].offset),
+		bytes.NewReader(xzIndex(blocks, stream.header[6:8])),
+		io.NewSectionReader(f, stream.end, info.Size()-stream.end),
+	), 0)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := io.CopyN(io.Discard, reader, offset-blockStart); err != nil {
+		return nil, false, err
+	}
+	return reader, true, nil
+}
@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// xzEncode returns an xz stream with one block for each of blocks. The data
+// is stored in uncompressed LZMA2 chunks, which is enough for the decoder.
+func xzEncode(blocks ...[]byte) []byte {
+	var buf bytes.Buffer
+	flags := []byte{0, 0} // No check
+	buf.Write(xzHeaderMagic)
+	buf.Write(flags)
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(flags))
+
+	index := []xzBlock{}
+	for _, data := range blocks {
+		start := buf.Len()
+		// A 12 byte header, for one filter: LZMA2 with a 4 KiB dictionary.
+		header := []byte{2, 0, 0x21, 1, 0, 0, 0, 0}
+		buf.Write(header)
+		binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(header))
+
+		control := byte(1) // Uncompressed, resetting the dictionary
+		for rest := data; len(rest) > 0; control = 2 {
+			n := len(rest)
+			if n > 1<<16 {
+				n = 1 << 16
+			}
+			buf.Write([]byte{control, byte((n - 1) >> 8), byte(n - 1)})
+			buf.Write(rest[:n])
+			rest = rest[n:]
+		}
+		buf.WriteByte(0)
+
+		index = append(index, xzBlock{unpadded: int64(buf.Len() - start),
+			uncompressed: int64(len(data))})
+		for buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	buf.Write(xzIndex(index, flags))
+	return buf.Bytes()
+}
+
+func TestOpenXZAt(t *testing.T) {
+	data := make([]byte, 300000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	a, b, c := data[:100000], data[100000:250000], data[250000:]
+
+	tests := []struct {
+		name  string
+		xz    []byte
+		seeks bool // Whether offsets past the first block skip it
+	}{
+		{"one block", xzEncode(data), false},
+		{"blocks", xzEncode(a, b, c), true},
+		{"streams", append(xzEncode(a, b), xzEncode(c)...), true},
+		{"padded streams", append(append(xzEncode(a), 0, 0, 0, 0), xzEncode(b, c)...), true},
+		{"empty block", xzEncode(a, nil, b, c), true},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		filename := filepath.Join(dir, "test.xz")
+		if err := os.WriteFile(filename, test.xz, 0666); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, seeks, err := openXZAt(f, 150000)
+		f.Close()
+		if err != nil || seeks != test.seeks {
+			t.Errorf("%s: openXZAt got %v, %v, expected %v", test.name, seeks, err, test.seeks)
+		}
+
+		for _, offset := range []int64{0, 1, 99999, 100000, 100001, 249999, 250000, 299999, 300000} {
+			r, err := OpenAt(filename, offset)
+			if err != nil {
+				t.Errorf("%s at %d: %s", test.name, offset, err)
+				continue
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(got, data[offset:]) {
+				t.Errorf("%s at %d: got %d bytes (%v), expected %d",
+					test.name, offset, len(got), err, len(data)-int(offset))
+			}
+		}
+	}
+
+	// A file whose index can't be read is decompressed from the start.
+	bad := xzEncode(a, b, c)
+	bad[len(bad)-16]++
+	filename := filepath.Join(dir, "bad.xz")
+	if err := os.WriteFile(filename, bad, 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, seeks, err := openXZAt(f, 150000); seeks || err != nil {
+		t.Errorf("bad index: got %v, %v, expected to read from the start", seeks, err)
+	}
+}
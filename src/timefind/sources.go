@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+  A SOURCE names a configuration file, NAME.conf.json, in one of the
+  directories of $TIMEFIND_CONFIG_PATH (a list like $PATH, "." if it's unset).
+  The first directory with NAME.conf.json wins.
+
+  A SOURCE can also be:
+
+    - a glob pattern (e.g., "dns*"), for every source it matches,
+    - a group, defined in a file named timefind-groups in one of the
+      directories, one group per line:
+
+        # Comments and blank lines are skipped.
+        netsec = pcap,dns,netflow
+        all-dns = dns*
+
+      The members of a group can be sources, patterns or other groups.
+    - the path of a configuration file (anything with a "/" in it, or ending
+      in .conf.json), as with --config.
+*/
+
+const configSuffix = ".conf.json"
+const groupsName = "timefind-groups"
+
+// A sourceResolver finds configuration files on the config path.
+type sourceResolver struct {
+	dirs    []string
+	configs map[string]string   // Configuration file by source name
+	names   []string            // Source names, sorted
+	groups  map[string][]string // Members by group name
+}
+
+func newSourceResolver(path string) (*sourceResolver, error) {
+	r := &sourceResolver{configs: map[string]string{}, groups: map[string][]string{}}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		r.dirs = append(r.dirs, dir)
+	}
+	if len(r.dirs) == 0 {
+		r.dirs = []string{"."}
+	}
+
+	for _, dir := range r.dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*"+configSuffix))
+		for _, filename := range matches {
+			name := sourceName(filename)
+			if _, ok := r.configs[name]; !ok {
+				r.configs[name] = filename
+				r.names = append(r.names, name)
+			}
+		}
+		if err := r.readGroups(filepath.Join(dir, groupsName)); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(r.names)
+	return r, nil
+}
+
+// sourceName returns the name of the source configured in filename, as
+// config.NewConfiguration works it out.
+func sourceName(filename string) string {
+	return strings.Split(filepath.Base(filename), ".")[0]
+}
+
+// readGroups reads the groups in filename, if it exists. Groups already
+// defined (in an earlier directory) are kept.
+func (r *sourceResolver) readGroups(filename string) error {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" {
+			return fmt.Errorf("%s:%d: expected NAME = SOURCE,SOURCE,...", filename, n)
+		}
+		members := []string{}
+		for _, m := range strings.Split(kv[1], ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				members = append(members, m)
+			}
+		}
+		if _, ok := r.groups[name]; !ok {
+			r.groups[name] = members
+		}
+	}
+	return scanner.Err()
+}
+
+// all returns the configuration file of every source on the config path.
+func (r *sourceResolver) all() []string {
+	configs := make([]string, len(r.names))
+	for i, name := range r.names {
+		configs[i] = r.configs[name]
+	}
+	return configs
+}
+
+// sources returns the configuration files to search, given the SOURCE
+// arguments, the --config paths and --all: the arguments' first, then the
+// --config files (with .conf.json added if it's missing), then the rest of
+// the sources on the config path if all is set.
+func (r *sourceResolver) sources(args []string, configPaths []string, all bool) ([]string, error) {
+	names := append([]string{}, args...)
+	for _, filename := range configPaths {
+		if !strings.HasSuffix(filename, configSuffix) {
+			filename += configSuffix
+		}
+		names = append(names, filename)
+	}
+	if all {
+		names = append(names, r.all()...)
+	}
+	return r.resolve(names)
+}
+
+// resolve returns the configuration files that the sources stand for, in
+// order and each only once.
+func (r *sourceResolver) resolve(sources []string) ([]string, error) {
+	configs := []string{}
+	seen := map[string]bool{}
+	add := func(filename string) {
+		if abs, err := filepath.Abs(filename); err == nil && !seen[abs] {
+			seen[abs] = true
+			configs = append(configs, filename)
+		}
+	}
+
+	var expand func(source string, groups []string) error
+	expand = func(source string, groups []string) error {
+		if strings.ContainsRune(source, '/') || strings.HasSuffix(source, configSuffix) {
+			if !isPattern(source) {
+				add(source)
+				return nil
+			}
+			matches, err := filepath.Glob(source)
+			if err != nil {
+				return fmt.Errorf("bad pattern %q: %s", source, err)
+			}
+			if len(matches) == 0 {
+				return fmt.Errorf("no configuration files match %s", source)
+			}
+			for _, filename := range matches {
+				add(filename)
+			}
+			return nil
+		}
+
+		if filename, ok := r.configs[source]; ok {
+			add(filename)
+			return nil
+		}
+		if members, ok := r.groups[source]; ok {
+			for _, g := range groups {
+				if g == source {
+					return fmt.Errorf("group %s includes itself", source)
+				}
+			}
+			for _, m := range members {
+				if err := expand(m, append(groups, source)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if isPattern(source) {
+			matched := false
+			for _, name := range r.names {
+				if ok, err := filepath.Match(source, name); err != nil {
+					return fmt.Errorf("bad pattern %q: %s", source, err)
+				} else if ok {
+					add(r.configs[name])
+					matched = true
+				}
+			}
+			if !matched {
+				return fmt.Errorf("no sources match %s in %s", source, strings.Join(r.dirs, ":"))
+			}
+			return nil
+		}
+		return fmt.Errorf("no source or group named %s in %s", source, strings.Join(r.dirs, ":"))
+	}
+
+	for _, source := range sources {
+		if err := expand(source, nil); err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
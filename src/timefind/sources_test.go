@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"timefind/internal/fixture"
+)
+
+// testConfigPath makes two config directories, and returns them as a
+// $TIMEFIND_CONFIG_PATH. Both have dns, so the first one's should win.
+func testConfigPath(t *testing.T) (string, string, string) {
+	first, second := t.TempDir(), t.TempDir()
+	fixture.Files(t, "", map[string]string{
+		filepath.Join(first, "dns.conf.json"):      "{}",
+		filepath.Join(first, "pcap.conf.json"):     "{}",
+		filepath.Join(second, "dns.conf.json"):     "{}",
+		filepath.Join(second, "dnssec.conf.json"):  "{}",
+		filepath.Join(second, "netflow.conf.json"): "{}",
+		filepath.Join(first, groupsName): strings.Join([]string{
+			"# Comments and blank lines are skipped",
+			"",
+			"netsec = pcap, dns,netflow",
+			"alldns = dns*",
+			"nested = netsec,alldns",
+			"loop = pcap,loop2",
+		}, "\n"),
+		filepath.Join(second, groupsName): strings.Join([]string{
+			"netsec = dnssec",
+			"loop2 = loop",
+		}, "\n"),
+	})
+	return first, second, first + string(filepath.ListSeparator) + second
+}
+
+func TestResolveSources(t *testing.T) {
+	first, second, path := testConfigPath(t)
+	dns := filepath.Join(first, "dns.conf.json")
+	pcap := filepath.Join(first, "pcap.conf.json")
+	dnssec := filepath.Join(second, "dnssec.conf.json")
+	netflow := filepath.Join(second, "netflow.conf.json")
+
+	r, err := newSourceResolver(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		configs []string
+		all     bool
+		want    []string // nil for an error
+	}{
+		{"first directory wins", []string{"dns"}, nil, false, []string{dns}},
+		{"names in order", []string{"netflow", "dns"}, nil, false, []string{netflow, dns}},
+		{"pattern", []string{"dns*"}, nil, false, []string{dns, dnssec}},
+		{"group", []string{"netsec"}, nil, false, []string{pcap, dns, netflow}},
+		{"nested groups, once each", []string{"nested"}, nil, false,
+			[]string{pcap, dns, netflow, dnssec}},
+		{"duplicates", []string{"dns", "alldns", dns}, nil, false, []string{dns, dnssec}},
+		{"arguments, then --config, then --all", []string{"netflow"}, []string{pcap}, true,
+			[]string{netflow, pcap, dns, dnssec}},
+		{"--config adds .conf.json", nil, []string{filepath.Join(second, "dns")}, false,
+			[]string{filepath.Join(second, "dns.conf.json")}},
+		{"path pattern", []string{filepath.Join(second, "d*.conf.json")}, nil, false,
+			[]string{filepath.Join(second, "dns.conf.json"), dnssec}},
+		{"group includes itself", []string{"loop"}, nil, false, nil},
+		{"unknown source", []string{"http"}, nil, false, nil},
+		{"unmatched pattern", []string{"http*"}, nil, false, nil},
+		{"unmatched path pattern", []string{filepath.Join(first, "http*.conf.json")}, nil, false, nil},
+	}
+
+	for _, test := range tests {
+		got, err := r.sources(test.args, test.configs, test.all)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%s: got %q, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadGroupsError(t *testing.T) {
+	dir := t.TempDir()
+	fixture.Files(t, dir, map[string]string{groupsName: "netsec pcap,dns\n"})
+	if _, err := newSourceResolver(dir); err == nil {
+		t.Error("read a group without an =")
+	}
+}
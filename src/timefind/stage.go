@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"timefind/config"
+	"timefind/index"
+	"timefind/processor"
+	tf_time "timefind/time"
+)
+
+/*
+  Staging copies (or links) the matching files into a directory, as
+  DIR/SOURCE/PATH where PATH is the file's path under the source's "paths".
+  Archive members are always copied out, as DIR/SOURCE/PATH/MEMBER where PATH
+  is the archive's, all the members of one archive in a single pass over it.
+
+  Files already staged by an earlier run are left alone, so an interrupted
+  stage can just be run again. Copies (and members) are written under a
+  temporary name and renamed into place once complete.
+
+  DIR/timefind-manifest.csv lists what was staged, and why:
+
+    #timefind-manifest,version=1,begin=1435708800.000000000,end=1435795200.000000000,mode=copy
+    staged,source,path,earliest,latest,status,windows
+    dns/2015/07/01.gz,dns,/data/dns/2015/07/01.gz,1435708800.000000000,1435795199.000000000,staged,1435708800.000000000/1435795200.000000000
+
+  where windows are the query windows the file matched, as space-separated
+  "earliest/latest" pairs.
+*/
+
+const manifestName = "timefind-manifest.csv"
+const manifestMagic = "#timefind-manifest"
+
+var stageModes = []string{"copy", "hardlink", "symlink", "reflink"}
+
+// A stageItem is a matching file and where it's staged.
+type stageItem struct {
+	source  string
+	entry   index.Entry
+	windows []tf_time.Times // The query windows the entry matched
+	dest    string          // Relative to the staging directory
+	archive string          // For archive members, the archive,
+	member  string          // the member,
+	dir     string          // and where ExtractMembers puts it
+	status  string          // "staged", "existing" or "failed"
+}
+
+type stager struct {
+	dir   string
+	mode  string
+	jobs  int
+	items []stageItem
+	dests map[string]string // Data file by staged path, to catch collisions
+}
+
+func newStager(dir string, mode string, jobs int) (*stager, error) {
+	ok := false
+	for _, m := range stageModes {
+		ok = ok || m == mode
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown stage mode %q (must be one of %s)",
+			mode, strings.Join(stageModes, ", "))
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &stager{dir: dir, mode: mode, jobs: jobs, dests: map[string]string{}}, nil
+}
+
+// add adds the entries of the source cfg to be staged. matched holds the
+// indexes in windows of the windows that each entry matched, as returned by
+// Index.FindWindows.
+func (s *stager) add(cfg *config.Configuration, entries []index.Entry,
+	windows []tf_time.Times, matched [][]int) error {
+
+	for i, entry := range entries {
+		path := entry.Path
+		member := ""
+		if archive, m, ok := processor.SplitArchivePath(path); ok {
+			path, member = archive, m
+		}
+
+		rel, err := stagePath(cfg, path)
+		if err != nil {
+			return err
+		}
+		dest, dir := filepath.Join(cfg.Name, rel), ""
+		if member != "" {
+			dir = filepath.Dir(dest)
+			dest = processor.MemberPath(path, member, dir)
+		}
+
+		if other, ok := s.dests[dest]; ok {
+			return fmt.Errorf("%s and %s would both be staged as %s", other, entry.Path, dest)
+		}
+		s.dests[dest] = entry.Path
+		item := stageItem{source: cfg.Name, entry: entry, dest: dest, dir: dir}
+		if member != "" {
+			item.archive, item.member = path, member
+		}
+		for _, n := range matched[i] {
+			item.windows = append(item.windows, windows[n])
+		}
+		s.items = append(s.items, item)
+	}
+	return nil
+}
+
+// stagePath returns path relative to the one of the source's "paths" it's
+// under.
+func stagePath(cfg *config.Configuration, path string) (string, error) {
+	for _, base := range cfg.Paths {
+		rel, err := filepath.Rel(base, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return rel, nil
+		}
+	}
+	return "", fmt.Errorf("%s: not under any of the paths of %s", path, cfg.Name)
+}
+
+// run stages every file, with up to s.jobs at a time, and writes the
+// manifest. It returns an error if any file couldn't be staged.
+func (s *stager) run(earliest time.Time, latest time.Time) error {
+	// Each job is a file, or all the members of one archive.
+	jobs := [][]int{}
+	archives := map[string]int{} // Job by archive and where it's extracted to
+	for n, item := range s.items {
+		if item.archive == "" {
+			jobs = append(jobs, []int{n})
+			continue
+		}
+		key := item.archive + "\x00" + item.dir
+		if j, ok := archives[key]; ok {
+			jobs[j] = append(jobs[j], n)
+		} else {
+			archives[key] = len(jobs)
+			jobs = append(jobs, []int{n})
+		}
+	}
+
+	work := make(chan []int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+
+	for i := 0; i < s.jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				var errs []error
+				var existing []bool
+				if s.items[job[0]].archive != "" {
+					existing, errs = s.extract(job)
+				} else {
+					e, err := s.stage(&s.items[job[0]])
+					existing, errs = []bool{e}, []error{err}
+				}
+
+				mu.Lock()
+				for i, n := range job {
+					item := &s.items[n]
+					if errs[i] != nil {
+						log.Printf("Could not stage %s: %s", item.entry.Path, errs[i])
+						item.status = "failed"
+						failed++
+					} else if existing[i] {
+						item.status = "existing"
+					} else {
+						item.status = "staged"
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, job := range jobs {
+		work <- job
+	}
+	close(work)
+	wg.Wait()
+
+	if err := s.writeManifest(earliest, latest); err != nil {
+		return fmt.Errorf("Could not write manifest: %s", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("could not stage %d of %d files", failed, len(s.items))
+	}
+	return nil
+}
+
+// extract stages the members of one archive, s.items[n] for each n in job,
+// and returns whether each was already staged, and any error staging it.
+func (s *stager) extract(job []int) (existing []bool, errs []error) {
+	existing, errs = make([]bool, len(job)), make([]error, len(job))
+	first := s.items[job[0]]
+
+	info, err := os.Stat(first.archive)
+	if err != nil {
+		for i := range job {
+			errs[i] = err
+		}
+		return existing, errs
+	}
+
+	// Members extracted by an earlier run have the archive's modification
+	// time; see processor.ExtractMembers.
+	members := []string{}
+	for i, n := range job {
+		item := s.items[n]
+		dest, err := os.Stat(filepath.Join(s.dir, item.dest))
+		if err == nil && dest.Mode().IsRegular() && dest.Size() == item.entry.Uncompressed &&
+			dest.ModTime().Equal(info.ModTime()) {
+			existing[i] = true
+		} else {
+			members = append(members, item.member)
+		}
+	}
+	if len(members) == 0 {
+		return existing, errs
+	}
+
+	dests, err := processor.ExtractMembers(first.archive, members, filepath.Join(s.dir, first.dir))
+	for i, n := range job {
+		item := s.items[n]
+		if _, ok := dests[item.member]; ok || existing[i] {
+			continue
+		}
+		if err != nil {
+			errs[i] = err
+		} else {
+			errs[i] = fmt.Errorf("%s: no member named %q", item.archive, item.member)
+		}
+	}
+	return existing, errs
+}
+
+// stage stages one file. existing is true if it was already staged.
+func (s *stager) stage(item *stageItem) (existing bool, err error) {
+	dest := filepath.Join(s.dir, item.dest)
+	if err := os.MkdirAll(filepath.Dir(dest), 0777); err != nil {
+		return false, err
+	}
+
+	src := item.entry.Path
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	if staged(src, srcInfo, dest, s.mode) {
+		return true, nil
+	}
+
+	tmp := dest + ".partial"
+	os.Remove(tmp)
+	switch s.mode {
+	case "hardlink":
+		err = os.Link(src, tmp)
+	case "symlink":
+		err = os.Symlink(src, tmp)
+	default:
+		err = copyData(src, srcInfo, tmp, s.mode == "reflink")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return false, os.Rename(tmp, dest)
+}
+
+// staged reports whether dest is already src staged in mode.
+func staged(src string, srcInfo os.FileInfo, dest string, mode string) bool {
+	info, err := os.Lstat(dest)
+	if err != nil {
+		return false
+	}
+	switch mode {
+	case "hardlink":
+		return os.SameFile(info, srcInfo)
+	case "symlink":
+		target, err := os.Readlink(dest)
+		return err == nil && target == src
+	default:
+		return info.Mode().IsRegular() && info.Size() == srcInfo.Size() &&
+			info.ModTime().Equal(srcInfo.ModTime())
+	}
+}
+
+// Linux's FICLONE ioctl, which makes dst share src's blocks on file systems
+// that support it (e.g., btrfs and XFS).
+const ficlone = 0x40049409
+
+// copyData copies src to dst, or clones it if reflink is set, and gives dst
+// the modification time of src.
+func copyData(src string, srcInfo os.FileInfo, dst string, reflink bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, srcInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if reflink {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ficlone, in.Fd())
+		if errno != 0 {
+			err = fmt.Errorf("reflink not supported: %s", errno)
+		}
+	} else {
+		_, err = io.Copy(out, in)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+}
+
+func (s *stager) writeManifest(earliest time.Time, latest time.Time) error {
+	filename := filepath.Join(s.dir, manifestName)
+	if err := os.MkdirAll(s.dir, 0777); err != nil {
+		return err
+	}
+
+	tmpfn := filename + ".new"
+	f, err := os.Create(tmpfn)
+	if err != nil {
+		return err
+	}
+
+	// An open end of the query is left out.
+	header := []string{manifestMagic, "version=1"}
+	if begin := formatUnixTime(earliest); begin != "" {
+		header = append(header, "begin="+begin)
+	}
+	if end := formatUnixTime(latest); end != "" {
+		header = append(header, "end="+end)
+	}
+	w := csv.NewWriter(f)
+	w.Write(append(header, "mode="+s.mode))
+	w.Write([]string{"staged", "source", "path", "earliest", "latest", "status", "windows"})
+	for _, item := range s.items {
+		windows := make([]string, len(item.windows))
+		for i, window := range item.windows {
+			windows[i] = periodString(window)
+		}
+		w.Write([]string{item.dest, item.source, item.entry.Path,
+			formatUnixTime(item.entry.Period.Earliest), formatUnixTime(item.entry.Period.Latest),
+			item.status, strings.Join(windows, " ")})
+	}
+	w.Flush()
+
+	err = w.Error()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpfn)
+		return err
+	}
+	return os.Rename(tmpfn, filename)
+}
+
+// periodString returns period as "earliest/latest" in Unix times, with an
+// open end left empty.
+func periodString(period tf_time.Times) string {
+	return formatUnixTime(period.Earliest) + "/" + formatUnixTime(period.Latest)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"timefind/config"
+	"timefind/index"
+	"timefind/internal/fixture"
+	"timefind/processor"
+	tf_time "timefind/time"
+)
+
+// stageSource writes the data files of a source named "dns" under a temporary
+// directory and returns its configuration and index entries.
+func stageSource(t *testing.T) (*config.Configuration, []index.Entry) {
+	names := []string{"2015/07/01.gz", "2015/07/02.gz"}
+	cfg := fixture.Source(t, "dns", "", map[string]string{names[0]: names[0], names[1]: names[1]})
+	entries := []index.Entry{}
+	for i, name := range names {
+		filename := filepath.Join(cfg.Paths[0], name)
+		day := int64(1435708800 + 86400*i)
+		entries = append(entries, index.Entry{Path: filename,
+			Period: tf_time.Times{Earliest: time.Unix(day, 0), Latest: time.Unix(day+86399, 0)}})
+	}
+	return cfg, entries
+}
+
+func readManifest(t *testing.T, dir string) [][]string {
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	recs, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func TestStage(t *testing.T) {
+	windows := []tf_time.Times{
+		{Earliest: time.Unix(1435708800, 0), Latest: time.Unix(1435712400, 0)},
+		{Earliest: time.Unix(1435795200, 0), Latest: time.Unix(1435798800, 0)},
+		{Earliest: time.Unix(1435780000, 0), Latest: time.Unix(1435800000, 0)},
+	}
+	matched := [][]int{{0, 2}, {1, 2}}
+
+	for _, mode := range []string{"copy", "hardlink", "symlink"} {
+		cfg, entries := stageSource(t)
+		dir := filepath.Join(t.TempDir(), "stage")
+
+		for run, status := range []string{"staged", "existing"} {
+			s, err := newStager(dir, mode, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.add(cfg, entries, windows, matched); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.run(windows[0].Earliest, windows[1].Latest); err != nil {
+				t.Errorf("%s, run %d: %s", mode, run, err)
+				continue
+			}
+
+			for _, entry := range entries {
+				rel, _ := filepath.Rel(cfg.Paths[0], entry.Path)
+				dest := filepath.Join(dir, "dns", rel)
+				data, err := os.ReadFile(dest)
+				if err != nil || string(data) != rel {
+					t.Errorf("%s: %s has %q (%v)", mode, dest, data, err)
+				}
+				info, _ := os.Lstat(dest)
+				if symlink := info.Mode()&os.ModeSymlink != 0; symlink != (mode == "symlink") {
+					t.Errorf("%s: %s is a symlink: %v", mode, dest, symlink)
+				}
+			}
+
+			recs := readManifest(t, dir)
+			if len(recs) != 4 || !strings.HasPrefix(recs[0][0], manifestMagic) ||
+				recs[0][4] != "mode="+mode {
+				t.Fatalf("%s: manifest %v", mode, recs)
+			}
+			want := []string{"dns/2015/07/01.gz", "dns", entries[0].Path,
+				"1435708800.000000000", "1435795199.000000000", status,
+				"1435708800.000000000/1435712400.000000000 1435780000.000000000/1435800000.000000000"}
+			if !reflect.DeepEqual(recs[2], want) {
+				t.Errorf("%s, run %d: manifest has\n%q\nexpected\n%q", mode, run, recs[2], want)
+			}
+		}
+		if matches, _ := filepath.Glob(filepath.Join(dir, "dns", "2015", "07", "*.partial")); len(matches) > 0 {
+			t.Errorf("%s: left behind %v", mode, matches)
+		}
+	}
+}
+
+func TestStageChanged(t *testing.T) {
+	cfg, entries := stageSource(t)
+	dir := t.TempDir()
+	matched := [][]int{{0}, {0}}
+	windows := []tf_time.Times{{Earliest: tf_time.MinTime, Latest: tf_time.MaxTime}}
+
+	stage := func() {
+		s, err := newStager(dir, "copy", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.add(cfg, entries, windows, matched); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.run(tf_time.MinTime, tf_time.MaxTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stage()
+
+	// A data file that changed since it was staged is copied again.
+	fixture.Files(t, "", map[string]string{entries[0].Path: "rewritten"})
+	stage()
+	recs := readManifest(t, dir)
+	if recs[2][5] != "staged" || recs[3][5] != "existing" {
+		t.Errorf("got statuses %s and %s, expected staged and existing", recs[2][5], recs[3][5])
+	}
+
+	// The query has no begin or end time, so neither is written.
+	if want := []string{manifestMagic, "version=1", "mode=copy"}; !reflect.DeepEqual(recs[0], want) {
+		t.Errorf("manifest header %q, expected %q", recs[0], want)
+	}
+	if recs[2][6] != "/" {
+		t.Errorf("manifest windows %q, expected an open window", recs[2][6])
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "dns", "2015", "07", "01.gz")); string(data) != "rewritten" {
+		t.Errorf("staged %q, expected the new contents", data)
+	}
+}
+
+func TestStageErrors(t *testing.T) {
+	if _, err := newStager(t.TempDir(), "move", 1); err == nil {
+		t.Error("mode move: expected an error")
+	}
+
+	cfg, entries := stageSource(t)
+	s, err := newStager(t.TempDir(), "copy", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := index.Entry{Path: "/elsewhere/01.gz"}
+	if err := s.add(cfg, []index.Entry{outside}, nil, [][]int{nil}); err == nil {
+		t.Error("a file outside the source's paths: expected an error")
+	}
+
+	// Two sources with the same name and layout would collide.
+	other := fixture.Source(t, "dns", "", nil)
+	moved := index.Entry{Path: filepath.Join(other.Paths[0], "2015/07/01.gz")}
+	if err := s.add(cfg, entries[:1], nil, [][]int{nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.add(other, []index.Entry{moved}, nil, [][]int{nil}); err == nil ||
+		!strings.Contains(err.Error(), "would both be staged") {
+		t.Errorf("colliding files: got %v", err)
+	}
+}
+
+func TestStageArchive(t *testing.T) {
+	cfg, _ := stageSource(t)
+	archive := filepath.Join(cfg.Paths[0], "2015", "bundle.tar")
+	members := map[string]string{"a.log": "first\n", "logs/b.log": "second\n"}
+	fixture.Archive(t, archive, fixture.Member{Name: "a.log", Data: members["a.log"]},
+		fixture.Member{Name: "logs/b.log", Data: members["logs/b.log"]})
+
+	entries := []index.Entry{}
+	for _, name := range []string{"a.log", "logs/b.log"} {
+		entries = append(entries, index.Entry{Path: archive + processor.ArchiveSep + name,
+			Uncompressed: int64(len(members[name]))})
+	}
+	dir := t.TempDir()
+	stage := func(entries []index.Entry) ([][]string, error) {
+		s, err := newStager(dir, "copy", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.add(cfg, entries, nil, make([][]int, len(entries))); err != nil {
+			t.Fatal(err)
+		}
+		err = s.run(tf_time.MinTime, tf_time.MaxTime)
+		return readManifest(t, dir), err
+	}
+	check := func(run string, recs [][]string, statuses ...string) {
+		for i, status := range statuses {
+			if recs[i+2][5] != status {
+				t.Errorf("%s: %s is %s, expected %s", run, recs[i+2][2], recs[i+2][5], status)
+			}
+		}
+		for name, data := range members {
+			staged := filepath.Join(dir, "dns", "2015", "bundle.tar", name)
+			if got, err := os.ReadFile(staged); err != nil || string(got) != data {
+				t.Errorf("%s: staged %s as %q, %v, expected %q", run, name, got, err, data)
+			}
+		}
+		if matches, _ := filepath.Glob(filepath.Join(dir, "dns", "2015", "bundle.tar", "*.partial")); len(matches) > 0 {
+			t.Errorf("%s: left behind %v", run, matches)
+		}
+	}
+
+	recs, err := stage(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("first run", recs, "staged", "staged")
+
+	// A member with the right size but not from this archive (e.g., from
+	// a run interrupted some other way) is extracted again.
+	damaged := filepath.Join(dir, "dns", "2015", "bundle.tar", "a.log")
+	fixture.Files(t, "", map[string]string{damaged: "xxxxx\n"})
+	recs, err = stage(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("second run", recs, "staged", "existing")
+
+	// A member that isn't in the archive fails on its own.
+	missing := index.Entry{Path: archive + processor.ArchiveSep + "missing.log"}
+	recs, err = stage(append(entries, missing))
+	if err == nil {
+		t.Error("staged a missing member without an error")
+	}
+	check("missing member", recs, "existing", "existing", "failed")
+}
@@ -0,0 +1,302 @@
+package time
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Seconds from year 1 (where time.Time counts from) to 1970.
+const unixToInternal int64 = (1969*365 + 1969/4 - 1969/100 + 1969/400) * 24 * 60 * 60
+
+// MinTime and MaxTime stand for the open ends of a query: one without a
+// begin time starts at MinTime, and one without an end time ends at MaxTime,
+// so that no data is ever left out, not from before year 1 or 1970, and not
+// from after 2038 either. They are the earliest and latest times whose Unix
+// time fits in an int64 (MaxTime is also the latest time.Time there is), and
+// are only for comparing with: output leaves open ends out.
+var (
+	MinTime = time.Unix(math.MinInt64, 0).UTC()
+	MaxTime = time.Unix(math.MaxInt64-unixToInternal, 999999999).UTC()
+)
+
+// The formats ParseTimeIn accepts with a time zone, besides Unix time.
+var TimeLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+}
+
+// The formats ParseTimeIn accepts without a time zone, which are taken to be
+// in the zone it's given.
+var LocalTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime parses a time given on the command line, as ParseTimeIn does,
+// in UTC.
+func ParseTime(timestr string) (time.Time, error) {
+	return ParseTimeIn(timestr, time.Now(), time.UTC)
+}
+
+// ParseTimeIn parses a time given on the command line:
+//
+//   - in RFC3339 format, e.g., "2015-07-14T23:52:57Z"
+//   - as a date and time without a zone, in loc, e.g., "2015-07-14 23:52"
+//     or "2015-07-14" (midnight)
+//   - as a Unix timestamp with optional fractional seconds, e.g.,
+//     "1445471780.5" is half a second after 1445471780
+//   - as "now", "today" or "yesterday" (midnight in loc), optionally
+//     followed by +DURATION or -DURATION, e.g., "now-2h" or "today+9h"
+func ParseTimeIn(timestr string, now time.Time, loc *time.Location) (time.Time, error) {
+	for _, layout := range TimeLayouts {
+		if t, err := time.Parse(layout, timestr); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range LocalTimeLayouts {
+		if t, err := time.ParseInLocation(layout, timestr, loc); err == nil {
+			return t, nil
+		}
+	}
+	if t, ok := parseRelative(timestr, now, loc); ok {
+		return t, nil
+	}
+
+	// time.Parse doesn't have a Unix timestamp layout
+	if t, err := parseUnixTime(timestr); err == nil {
+		return t, nil
+	}
+
+	// exhausted all ways to interpret input timestamps
+	return time.Time{}, fmt.Errorf("could not parse timestamp; check format: %q", timestr)
+}
+
+// parseRelative parses "now", "today" and "yesterday", with an optional
+// offset.
+func parseRelative(timestr string, now time.Time, loc *time.Location) (time.Time, bool) {
+	base, offset := timestr, ""
+	if i := strings.IndexAny(timestr, "+-"); i >= 0 {
+		base, offset = timestr[:i], timestr[i:]
+	}
+
+	var t time.Time
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch base {
+	case "now":
+		t = now
+	case "today":
+		t = today
+	case "yesterday":
+		t = today.AddDate(0, 0, -1)
+	default:
+		return time.Time{}, false
+	}
+
+	if offset == "" {
+		return t, true
+	}
+	d, err := ParseDuration(offset[1:])
+	if err != nil || strings.HasPrefix(offset[1:], "-") {
+		return time.Time{}, false
+	}
+	if offset[0] == '-' {
+		d = -d
+	}
+	return t.Add(d), true
+}
+
+// ParseDuration parses a duration as time.ParseDuration does, but also
+// allows leading numbers of weeks and days, e.g., "1w", "2d" or "1d12h".
+func ParseDuration(s string) (time.Duration, error) {
+	total := time.Duration(0)
+	rest := s
+	for _, unit := range []struct {
+		suffix string
+		length time.Duration
+	}{{"w", 7 * 24 * time.Hour}, {"d", 24 * time.Hour}} {
+		i := strings.Index(rest, unit.suffix)
+		if i < 0 {
+			continue
+		}
+		n, err := strconv.ParseUint(rest[:i], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		total += time.Duration(n) * unit.length
+		rest = rest[i+1:]
+	}
+	if rest == "" && rest != s {
+		return total, nil
+	}
+	if rest != s && strings.IndexAny(rest, "+-") == 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	d, err := time.ParseDuration(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return total + d, nil
+}
+
+// A Query describes the interval a search is for, as given on the command
+// line. Begin and End are times as ParseTimeIn takes them, and Last and
+// Duration durations as ParseDuration takes them. Any of them may be empty.
+type Query struct {
+	Begin    string
+	End      string
+	Last     string // The interval is this long, up to End (or now)
+	Duration string // The interval is this long, from Begin
+
+	// Separate windows to search, as ParseWindow takes them. With Every,
+	// they repeat that often within the interval above.
+	Windows []string
+	Every   string
+
+	Location *time.Location // For times without a zone; UTC if nil
+	Now      time.Time      // For relative times; time.Now() if zero
+}
+
+// ParseBounds parses the begin and end times of a query, in UTC. See
+// Query.Bounds.
+func ParseBounds(begin string, end string) (Times, error) {
+	return Query{Begin: begin, End: end}.Bounds()
+}
+
+// TimeWindows returns the windows the query is for, sorted by their begin
+// time: the Windows, or their repeats, or else just the interval from Bounds.
+func (q Query) TimeWindows() ([]Times, error) {
+	if len(q.Windows) == 0 {
+		if q.Every != "" {
+			return nil, fmt.Errorf("only windows can recur")
+		}
+		bounds, err := q.Bounds()
+		if err != nil {
+			return nil, err
+		}
+		return []Times{bounds}, nil
+	}
+
+	if q.Every == "" && (q.Begin != "" || q.End != "" || q.Last != "" || q.Duration != "") {
+		return nil, fmt.Errorf("a begin or end time can only be given with windows that recur")
+	}
+
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	now := q.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	windows := []Times{}
+	for _, s := range q.Windows {
+		w, err := ParseWindow(s, now, loc)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	if q.Every == "" {
+		SortWindows(windows)
+		return windows, nil
+	}
+
+	every, err := ParseDuration(q.Every)
+	if err != nil {
+		return nil, err
+	}
+	q.Now = now
+	bounds, err := q.Bounds()
+	if err != nil {
+		return nil, err
+	}
+	return Recur(windows, every, bounds, loc)
+}
+
+// Bounds returns the interval the query is for. Without a begin (or end)
+// time, or a duration to work it out from, it starts at MinTime (or ends at
+// MaxTime). Both ends are included in the query.
+func (q Query) Bounds() (Times, error) {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	now := q.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	if q.Last != "" && q.Begin != "" {
+		return Times{}, fmt.Errorf("a begin time can't be given with a last duration")
+	}
+	if q.Duration != "" && (q.Begin == "" || q.End != "") {
+		return Times{}, fmt.Errorf("a duration needs a begin time, and no end time")
+	}
+
+	bounds := Times{Earliest: MinTime, Latest: MaxTime}
+
+	var err error
+	if q.Begin != "" {
+		if bounds.Earliest, err = ParseTimeIn(q.Begin, now, loc); err != nil {
+			return Times{}, err
+		}
+	}
+	if q.End != "" {
+		if bounds.Latest, err = ParseTimeIn(q.End, now, loc); err != nil {
+			return Times{}, err
+		}
+	}
+	if q.Last != "" {
+		d, err := ParseDuration(q.Last)
+		if err != nil {
+			return Times{}, err
+		}
+		if q.End == "" {
+			bounds.Latest = now
+		}
+		bounds.Earliest = bounds.Latest.Add(-d)
+	}
+	if q.Duration != "" {
+		d, err := ParseDuration(q.Duration)
+		if err != nil {
+			return Times{}, err
+		}
+		bounds.Latest = bounds.Earliest.Add(d)
+	}
+
+	if bounds.Latest.Before(bounds.Earliest) {
+		return Times{}, fmt.Errorf("end time %s is before begin time %s",
+			bounds.Latest, bounds.Earliest)
+	}
+	return bounds, nil
+}
+
+// Overlaps reports whether the period tm has any time in common with
+// window, both ends included.
+func (tm Times) Overlaps(window Times) bool {
+	return !tm.Latest.Before(window.Earliest) && !tm.Earliest.After(window.Latest)
+}
+
+// Within reports whether the period tm lies entirely inside window.
+func (tm Times) Within(window Times) bool {
+	return !tm.Earliest.Before(window.Earliest) && !tm.Latest.After(window.Latest)
+}
+
+// Covers reports whether the period tm contains all of window.
+func (tm Times) Covers(window Times) bool {
+	return window.Within(tm)
+}
+
+// Contains reports whether the period tm contains the instant t.
+func (tm Times) Contains(t time.Time) bool {
+	return !t.Before(tm.Earliest) && !t.After(tm.Latest)
+}
@@ -26,14 +26,22 @@ func (tm *Times) Union(add_period Times) {
 }
 
 // Converts Time t into a Unix timestamp with nanosecond precision
-// in the format of: s.nnnnnnnnn
+// in the format of: s.nnnnnnnnn (-s.nnnnnnnnn before 1970, so that
+// -1.500000000 is a second and a half before)
 func MarshalTime(t time.Time) ([]byte, error) {
 
 	if unixTime == true {
 		sec := t.Unix()
-		nsec := t.Nanosecond()
-
-		return []byte(fmt.Sprintf("%d.%09d", sec, nsec)), nil
+		nsec := int64(t.Nanosecond())
+
+		sign := ""
+		if sec < 0 {
+			sign, sec = "-", -sec
+			if nsec > 0 {
+				sec, nsec = sec-1, 1000000000-nsec
+			}
+		}
+		return []byte(fmt.Sprintf("%s%d.%09d", sign, sec, nsec)), nil
 	} else {
 		return t.MarshalText()
 	}
@@ -48,36 +56,56 @@ func UnmarshalTime(data []byte) (time.Time, error) {
 	err := t.UnmarshalText(data)
 
 	if err == nil {
-		return t, err
+		return t.UTC(), err
 	}
 
 	// next try Unix timestamp with and without ns precision
-	s := strings.Split(string(data[:]), ".")
+	return parseUnixTime(string(data[:]))
+}
+
+// parseUnixTime parses a Unix timestamp with optional fractional seconds.
+// The sign applies to the fraction too, so "-2.5" is two and a half seconds
+// before 1970.
+func parseUnixTime(str string) (time.Time, error) {
+	s := strings.Split(str, ".")
 
 	if len(s) > 2 {
-		return time.Time{}, fmt.Errorf("not a valid timestamp")
+		return time.Time{}, fmt.Errorf("not a valid timestamp = %s", str)
 	}
 
 	sec, err := strconv.ParseInt(s[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
 	var nsec int64 = 0
 
 	if len(s) == 2 {
-		nsec, err = strconv.ParseInt(s[1], 10, 64)
+		if nsec, err = parseFraction(s[1]); err != nil {
+			return time.Time{}, err
+		}
+		if strings.HasPrefix(s[0], "-") {
+			nsec = -nsec
+		}
 	}
 
-	return time.Unix(sec, nsec), err
+	return time.Unix(sec, nsec).UTC(), nil
 }
 
-func UnixTimeToGoTime(data []byte) (time.Time, error) {
-	// assume properly formatted Unix timestamp with nanosecond precision
-	s := strings.Split(string(data[:]), ".")
-
-	if len(s) > 2 {
-		return time.Time{}, fmt.Errorf("not a valid timestamp = %s", string(data[:]))
+// parseFraction returns the nanoseconds in the digits after a decimal point,
+// so that "5" is 500000000 and "000000005" is 5. Digits beyond nanoseconds
+// are dropped.
+func parseFraction(digits string) (int64, error) {
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return 0, fmt.Errorf("not a valid fraction of a second: %s", digits)
 	}
+	if len(digits) > 9 {
+		digits = digits[:9]
+	}
+	nsec, err := strconv.ParseInt(digits+strings.Repeat("0", 9-len(digits)), 10, 64)
+	return nsec, err
+}
 
-	sec, _ := strconv.ParseInt(s[0], 10, 64)
-	nsec, _ := strconv.ParseInt(s[1], 10, 64)
-
-	return time.Unix(sec, nsec), nil
+func UnixTimeToGoTime(data []byte) (time.Time, error) {
+	// assume properly formatted Unix timestamp with nanosecond precision
+	return parseUnixTime(string(data[:]))
 }
@@ -53,6 +53,66 @@ func TestUnmarshalFromUnixTimeWithoutNanosecondPrecision(t *testing.T) {
 	}
 }
 
+func TestUnmarshalFraction(t *testing.T) {
+	unixTime = true
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"1436000100.5", time.Unix(1436000100, 500000000)},
+		{"1436000100.05", time.Unix(1436000100, 50000000)},
+		{"1436000100.000000005", time.Unix(1436000100, 5)},
+		{"1436000100.123456789", time.Unix(1436000100, 123456789)},
+		{"1436000100.1234567891", time.Unix(1436000100, 123456789)},
+		{"1436000100.0", time.Unix(1436000100, 0)},
+		// The sign applies to the fraction too.
+		{"-2.500000000", time.Unix(-2, -500000000)},
+		{"-0.5", time.Unix(0, -500000000)},
+		{"-2", time.Unix(-2, 0)},
+	}
+	for _, test := range tests {
+		got, err := UnmarshalTime([]byte(test.in))
+		if err != nil {
+			t.Errorf("UnmarshalTime(%q): %s", test.in, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("UnmarshalTime(%q) = %s, expected %s", test.in, got, test.want)
+		}
+		if got, _ := UnixTimeToGoTime([]byte(test.in)); !got.Equal(test.want) {
+			t.Errorf("UnixTimeToGoTime(%q) = %s, expected %s", test.in, got, test.want)
+		}
+
+		if got, err := ParseTime(test.in); err != nil || !got.Equal(test.want) {
+			t.Errorf("ParseTime(%q) = %s, %v, expected %s", test.in, got, err, test.want)
+		}
+
+		// And back again.
+		m, _ := MarshalTime(test.want)
+		if back, err := UnmarshalTime(m); err != nil || !back.Equal(test.want) {
+			t.Errorf("UnmarshalTime(MarshalTime(%s)) = %s, %v", test.want, back, err)
+		}
+	}
+
+	for _, test := range []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Unix(-2, -500000000), "-2.500000000"},
+		{time.Unix(0, -500000000), "-0.500000000"},
+		{time.Unix(-2, 0), "-2.000000000"},
+		{time.Unix(2, 500000000), "2.500000000"},
+	} {
+		if got, _ := MarshalTime(test.t); string(got) != test.want {
+			t.Errorf("MarshalTime(%s) = %s, expected %s", test.t, got, test.want)
+		}
+	}
+
+	for _, in := range []string{"1.", "1.x", "1.-5", "1.+5", "x.5", "1.2.3"} {
+		if got, err := UnmarshalTime([]byte(in)); err == nil {
+			t.Errorf("UnmarshalTime(%q) = %s, expected an error", in, got)
+		}
+	}
+}
+
 // Make sure that the output of MarshalText(t) is the same as
 // t.MarshalText()
 func TestMarshalToTime(t *testing.T) {
@@ -89,3 +149,396 @@ func TestUnmarshalFromTime(t *testing.T) {
 		t.Error("Expected 1325000, got ", cTime.Nanosecond())
 	}
 }
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2015-07-14", time.Date(2015, 7, 14, 0, 0, 0, 0, time.UTC)},
+		{"2015-07-14T23:52:57Z", time.Date(2015, 7, 14, 23, 52, 57, 0, time.UTC)},
+		{"2015-07-14T23:52:57.001325Z", time.Date(2015, 7, 14, 23, 52, 57, 1325000, time.UTC)},
+		{"1436917977", time.Unix(1436917977, 0)},
+		{"1436917977.001325000", time.Unix(1436917977, 1325000)},
+		{"1436917977.5", time.Unix(1436917977, 500000000)},
+		{"-1.5", time.Unix(-2, 500000000)},
+		{"4102444800", time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := ParseTime(test.in)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %s", test.in, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("ParseTime(%q) = %s, expected %s", test.in, got, test.want)
+		}
+	}
+
+	for _, in := range []string{"", "tomorrow", "2015-07-14 23", "1.x", "1.-5", "now-", "now-x", "today+-1h"} {
+		if got, err := ParseTime(in); err == nil {
+			t.Errorf("ParseTime(%q) = %s, expected an error", in, got)
+		}
+	}
+}
+
+func TestParseBounds(t *testing.T) {
+	// An empty begin or end leaves the interval open at that end, with
+	// nothing before 1970 or after 2038 left out.
+	bounds, err := ParseBounds("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bounds.Earliest.Equal(MinTime) || !bounds.Latest.Equal(MaxTime) {
+		t.Errorf("Expected [MinTime, MaxTime], got %v", bounds)
+	}
+	for _, tm := range []time.Time{
+		{},
+		time.Unix(-1, 0),
+		time.Unix(1<<31, 0),
+		time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC),
+	} {
+		if !bounds.Contains(tm) {
+			t.Errorf("Open interval doesn't contain %s", tm)
+		}
+	}
+	if !MinTime.Before(time.Time{}) {
+		t.Errorf("MinTime %s isn't before the zero time", MinTime)
+	}
+
+	bounds, err = ParseBounds("2015-07-14", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bounds.Earliest.Equal(time.Date(2015, 7, 14, 0, 0, 0, 0, time.UTC)) || !bounds.Latest.Equal(MaxTime) {
+		t.Errorf("Expected [2015-07-14, MaxTime], got %v", bounds)
+	}
+
+	bounds, err = ParseBounds("", "2015-07-14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bounds.Earliest.Equal(MinTime) || !bounds.Latest.Equal(time.Date(2015, 7, 14, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected [MinTime, 2015-07-14], got %v", bounds)
+	}
+
+	if _, err := ParseBounds("2015-07-14", "2015-07-13"); err == nil {
+		t.Error("Expected an error for an end before the begin")
+	}
+	if _, err := ParseBounds("2015-07-14", "2015-07-14"); err != nil {
+		t.Errorf("Expected an instant to be allowed, got %s", err)
+	}
+	if _, err := ParseBounds("bad", ""); err == nil {
+		t.Error("Expected an error for a bad begin time")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	period := func(a, b int64) Times {
+		return Times{Earliest: time.Unix(a, 0), Latest: time.Unix(b, 0)}
+	}
+	window := period(100, 200)
+
+	tests := []struct {
+		file                     Times
+		overlaps, within, covers bool
+	}{
+		{period(0, 50), false, false, false},
+		{period(0, 100), true, false, false}, // Ends are included
+		{period(50, 150), true, false, false},
+		{period(100, 200), true, true, true},
+		{period(120, 180), true, true, false},
+		{period(50, 250), true, false, true},
+		{period(100, 250), true, false, true},
+		{period(200, 300), true, false, false},
+		{period(201, 300), false, false, false},
+	}
+	for _, test := range tests {
+		if got := test.file.Overlaps(window); got != test.overlaps {
+			t.Errorf("%v.Overlaps(%v) = %t", test.file, window, got)
+		}
+		if got := test.file.Within(window); got != test.within {
+			t.Errorf("%v.Within(%v) = %t", test.file, window, got)
+		}
+		if got := test.file.Covers(window); got != test.covers {
+			t.Errorf("%v.Covers(%v) = %t", test.file, window, got)
+		}
+	}
+
+	// A file contains an instant exactly when it covers it as a window.
+	for _, at := range []int64{99, 100, 150, 200, 201} {
+		instant := period(at, at)
+		file := period(100, 200)
+		if file.Contains(time.Unix(at, 0)) != file.Covers(instant) {
+			t.Errorf("%v.Contains(%d) != %v.Covers(%v)", file, at, file, instant)
+		}
+		if file.Covers(instant) != (at >= 100 && at <= 200) {
+			t.Errorf("%v.Covers(%v) = %t", file, instant, file.Covers(instant))
+		}
+	}
+}
+
+func TestParseTimeIn(t *testing.T) {
+	eastern, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database: ", err)
+	}
+	now := time.Date(2015, 7, 14, 1, 30, 0, 0, time.UTC) // 21:30 the day before in New York
+
+	tests := []struct {
+		in   string
+		loc  *time.Location
+		want time.Time
+	}{
+		{"now", time.UTC, now},
+		{"now-2h", time.UTC, now.Add(-2 * time.Hour)},
+		{"now+90m", time.UTC, now.Add(90 * time.Minute)},
+		{"now-1d", time.UTC, now.Add(-24 * time.Hour)},
+		{"today", time.UTC, time.Date(2015, 7, 14, 0, 0, 0, 0, time.UTC)},
+		{"today+9h", time.UTC, time.Date(2015, 7, 14, 9, 0, 0, 0, time.UTC)},
+		{"yesterday", time.UTC, time.Date(2015, 7, 13, 0, 0, 0, 0, time.UTC)},
+		{"today", eastern, time.Date(2015, 7, 13, 0, 0, 0, 0, eastern)},
+		{"yesterday", eastern, time.Date(2015, 7, 12, 0, 0, 0, 0, eastern)},
+		{"2015-07-14", eastern, time.Date(2015, 7, 14, 4, 0, 0, 0, time.UTC)},
+		{"2015-07-14 23:52", eastern, time.Date(2015, 7, 15, 3, 52, 0, 0, time.UTC)},
+		{"2015-07-14T23:52:57.5", eastern, time.Date(2015, 7, 15, 3, 52, 57, 500000000, time.UTC)},
+		{"2015-07-14 23:52", time.UTC, time.Date(2015, 7, 14, 23, 52, 0, 0, time.UTC)},
+		// Times with a zone, and Unix times, don't depend on loc.
+		{"2015-07-14T23:52:57Z", eastern, time.Date(2015, 7, 14, 23, 52, 57, 0, time.UTC)},
+		{"1436917977", eastern, time.Unix(1436917977, 0)},
+	}
+	for _, test := range tests {
+		got, err := ParseTimeIn(test.in, now, test.loc)
+		if err != nil {
+			t.Errorf("ParseTimeIn(%q, %s): %s", test.in, test.loc, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("ParseTimeIn(%q, %s) = %s, expected %s", test.in, test.loc, got, test.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"90m", 90 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"2d", 48 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"1w", 7 * 24 * time.Hour},
+		{"1w1d1s", 8*24*time.Hour + time.Second},
+	}
+	for _, test := range tests {
+		got, err := ParseDuration(test.in)
+		if err != nil {
+			t.Errorf("ParseDuration(%q): %s", test.in, err)
+		} else if got != test.want {
+			t.Errorf("ParseDuration(%q) = %s, expected %s", test.in, got, test.want)
+		}
+	}
+
+	for _, in := range []string{"", "d", "1.5d", "-2d", "1d-1h", "2x"} {
+		if got, err := ParseDuration(in); err == nil {
+			t.Errorf("ParseDuration(%q) = %s, expected an error", in, got)
+		}
+	}
+}
+
+func TestQueryBounds(t *testing.T) {
+	now := time.Date(2015, 7, 14, 12, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time { return time.Date(2015, 7, 14, h, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		q    Query
+		want Times
+	}{
+		{Query{Last: "90m"}, Times{Earliest: now.Add(-90 * time.Minute), Latest: now}},
+		{Query{Last: "2h", End: "2015-07-14T06:00:00Z"}, Times{Earliest: at(4), Latest: at(6)}},
+		{Query{Begin: "today", Duration: "1h"}, Times{Earliest: at(0), Latest: at(1)}},
+		{Query{Begin: "now-1h"}, Times{Earliest: at(11), Latest: MaxTime}},
+		{Query{End: "today"}, Times{Earliest: MinTime, Latest: at(0)}},
+	}
+	for _, test := range tests {
+		test.q.Now = now
+		got, err := test.q.Bounds()
+		if err != nil {
+			t.Errorf("%+v: %s", test.q, err)
+		} else if !got.Earliest.Equal(test.want.Earliest) || !got.Latest.Equal(test.want.Latest) {
+			t.Errorf("%+v: got %v, expected %v", test.q, got, test.want)
+		}
+	}
+
+	for _, q := range []Query{
+		{Begin: "today", Last: "1h"},
+		{Duration: "1h"},
+		{Begin: "today", End: "now", Duration: "1h"},
+		{Last: "bad"},
+		{Begin: "now", End: "now-1h"},
+	} {
+		q.Now = now
+		if got, err := q.Bounds(); err == nil {
+			t.Errorf("%+v: got %v, expected an error", q, got)
+		}
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	now := time.Date(2015, 7, 14, 12, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time { return time.Date(2015, 7, 14, h, m, 0, 0, time.UTC) }
+
+	tests := []struct {
+		in   string
+		want Times
+	}{
+		{"2015-07-14T02:00:00Z/2015-07-14T03:00:00Z", Times{Earliest: at(2, 0), Latest: at(3, 0)}},
+		{"today+2h/today+3h", Times{Earliest: at(2, 0), Latest: at(3, 0)}},
+		{"2015-07-14 10:30~5m", Times{Earliest: at(10, 25), Latest: at(10, 35)}},
+		{"now", Times{Earliest: now, Latest: now}},
+	}
+	for _, test := range tests {
+		got, err := ParseWindow(test.in, now, time.UTC)
+		if err != nil {
+			t.Errorf("ParseWindow(%q): %s", test.in, err)
+		} else if !got.Earliest.Equal(test.want.Earliest) || !got.Latest.Equal(test.want.Latest) {
+			t.Errorf("ParseWindow(%q) = %v, expected %v", test.in, got, test.want)
+		}
+	}
+
+	for _, in := range []string{"", "now/", "now~", "now~x", "now/now-1h"} {
+		if got, err := ParseWindow(in, now, time.UTC); err == nil {
+			t.Errorf("ParseWindow(%q) = %v, expected an error", in, got)
+		}
+	}
+}
+
+func TestRecur(t *testing.T) {
+	eastern, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database: ", err)
+	}
+
+	// 02:00-03:00 every night in New York, across the change from daylight
+	// saving time on 2015-11-01.
+	window := Times{
+		Earliest: time.Date(2015, 10, 1, 2, 0, 0, 0, eastern),
+		Latest:   time.Date(2015, 10, 1, 3, 0, 0, 0, eastern),
+	}
+	bounds := Times{
+		Earliest: time.Date(2015, 10, 30, 2, 30, 0, 0, eastern),
+		Latest:   time.Date(2015, 11, 2, 12, 0, 0, 0, eastern),
+	}
+	got, err := Recur([]Times{window}, 24*time.Hour, bounds, eastern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Times{
+		{Earliest: bounds.Earliest, Latest: time.Date(2015, 10, 30, 3, 0, 0, 0, eastern)}, // Clipped
+		{Earliest: time.Date(2015, 10, 31, 2, 0, 0, 0, eastern), Latest: time.Date(2015, 10, 31, 3, 0, 0, 0, eastern)},
+		{Earliest: time.Date(2015, 11, 1, 2, 0, 0, 0, eastern), Latest: time.Date(2015, 11, 1, 3, 0, 0, 0, eastern)},
+		{Earliest: time.Date(2015, 11, 2, 2, 0, 0, 0, eastern), Latest: time.Date(2015, 11, 2, 3, 0, 0, 0, eastern)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Recur = %v, expected %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Earliest.Equal(want[i].Earliest) || !got[i].Latest.Equal(want[i].Latest) {
+			t.Errorf("Recur window %d = %v, expected %v", i, got[i], want[i])
+		}
+	}
+
+	// Other periods are plain durations, and windows from before or after
+	// the bounds repeat into them too.
+	base := time.Date(2015, 7, 14, 0, 0, 0, 0, time.UTC)
+	got, err = Recur([]Times{{Earliest: base.Add(100 * time.Hour), Latest: base.Add(101 * time.Hour)}},
+		6*time.Hour, Times{Earliest: base, Latest: base.Add(12 * time.Hour)}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].Earliest.Equal(base.Add(4*time.Hour)) || !got[1].Earliest.Equal(base.Add(10*time.Hour)) {
+		t.Errorf("Recur = %v, expected windows at 04:00 and 10:00", got)
+	}
+
+	if _, err := Recur([]Times{window}, time.Hour, Times{Earliest: MinTime, Latest: bounds.Latest}, eastern); err == nil {
+		t.Error("Expected an error for unbounded recurring windows")
+	}
+	if _, err := Recur([]Times{window}, 0, bounds, eastern); err == nil {
+		t.Error("Expected an error for a zero period")
+	}
+}
+
+func TestQueryTimeWindows(t *testing.T) {
+	now := time.Date(2015, 7, 14, 12, 0, 0, 0, time.UTC)
+
+	// Separate windows come back sorted.
+	q := Query{Windows: []string{"now~5m", "today+1h/today+2h"}, Now: now}
+	got, err := q.TimeWindows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Earliest.Hour() != 1 || got[1].Earliest.Hour() != 11 {
+		t.Errorf("TimeWindows = %v", got)
+	}
+
+	// Every night for the last 3 days.
+	q = Query{Windows: []string{"today+2h/today+3h"}, Every: "1d", Last: "3d", Now: now}
+	if got, err = q.TimeWindows(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Earliest.Day() != 12 || got[2].Earliest.Day() != 14 {
+		t.Errorf("TimeWindows = %v", got)
+	}
+
+	// Without windows, it's the interval.
+	q = Query{Begin: "today", End: "now", Now: now}
+	if got, err = q.TimeWindows(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].Latest.Equal(now) {
+		t.Errorf("TimeWindows = %v", got)
+	}
+
+	for _, q := range []Query{
+		{Windows: []string{"now~5m"}, Begin: "today"},
+		{Every: "1d", Last: "3d"},
+		{Windows: []string{"now~5m"}, Every: "1d"},
+		{Windows: []string{"bad"}},
+	} {
+		q.Now = now
+		if got, err := q.TimeWindows(); err == nil {
+			t.Errorf("%+v: got %v, expected an error", q, got)
+		}
+	}
+}
+
+func TestZones(t *testing.T) {
+	for _, name := range []string{"", "UTC"} {
+		if loc, err := LoadLocation(name); err != nil || loc != time.UTC {
+			t.Errorf("LoadLocation(%q) = %v, %v, expected UTC", name, loc, err)
+		}
+	}
+	if loc, err := LoadLocation("Local"); err != nil || loc != time.Local {
+		t.Errorf("LoadLocation(Local) = %v, %v", loc, err)
+	}
+	if _, err := LoadLocation("Nowhere/Special"); err == nil {
+		t.Errorf("LoadLocation(Nowhere/Special): expected an error")
+	}
+
+	// Times read back are in UTC, whatever the system's zone.
+	for _, s := range []string{"1436917977.001325000", "2015-07-15T01:52:57.001325+02:00"} {
+		got, err := UnmarshalTime([]byte(s))
+		if err != nil || got.Location() != time.UTC || got.Hour() != 23 {
+			t.Errorf("UnmarshalTime(%s) = %v, %v", s, got, err)
+		}
+	}
+
+	d, _ := time.Parse(time.RFC3339Nano, "2015-07-14T23:52:57.001325Z")
+	fixed := time.FixedZone("UTC-7", -7*60*60)
+	if got := Format(d, fixed); got != "2015-07-14T16:52:57.001325-07:00" {
+		t.Errorf("Format = %s", got)
+	}
+	if got := Format(d.In(fixed), nil); got != "2015-07-14T23:52:57.001325Z" {
+		t.Errorf("Format(nil) = %s", got)
+	}
+	if got := (Times{Earliest: d.In(fixed), Latest: d.In(fixed)}).UTC(); got.Earliest.Location() != time.UTC {
+		t.Errorf("Times.UTC = %v", got)
+	}
+}
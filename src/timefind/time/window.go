@@ -0,0 +1,115 @@
+package time
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The most windows Recur makes, so that a typo can't eat all the memory.
+const maxWindows = 100000
+
+// ParseWindow parses a time window given on the command line, as one of
+//
+//	BEGIN/END       from BEGIN to END
+//	TIME~DURATION   from DURATION before TIME to DURATION after it
+//	TIME            just the instant TIME
+//
+// with times as ParseTimeIn takes them, and durations as ParseDuration does.
+func ParseWindow(s string, now time.Time, loc *time.Location) (Times, error) {
+	var w Times
+	var err error
+
+	if i := strings.Index(s, "/"); i >= 0 {
+		if w.Earliest, err = ParseTimeIn(s[:i], now, loc); err != nil {
+			return Times{}, err
+		}
+		if w.Latest, err = ParseTimeIn(s[i+1:], now, loc); err != nil {
+			return Times{}, err
+		}
+	} else if i := strings.Index(s, "~"); i >= 0 {
+		t, err := ParseTimeIn(s[:i], now, loc)
+		if err != nil {
+			return Times{}, err
+		}
+		d, err := ParseDuration(s[i+1:])
+		if err != nil {
+			return Times{}, err
+		}
+		w = Times{Earliest: t.Add(-d), Latest: t.Add(d)}
+	} else {
+		if w.Earliest, err = ParseTimeIn(s, now, loc); err != nil {
+			return Times{}, err
+		}
+		w.Latest = w.Earliest
+	}
+
+	if w.Latest.Before(w.Earliest) {
+		return Times{}, fmt.Errorf("window %q ends before it begins", s)
+	}
+	return w, nil
+}
+
+// Recur repeats each of windows every period, forward and back, and returns
+// the repeats that overlap bounds, clipped to them and sorted by their begin
+// time. A period of a whole number of days is counted in calendar days in
+// loc, so that a window keeps its time of day across daylight saving time
+// changes.
+func Recur(windows []Times, every time.Duration, bounds Times, loc *time.Location) ([]Times, error) {
+	if every <= 0 {
+		return nil, fmt.Errorf("windows must recur every positive duration, not %s", every)
+	}
+	if bounds.Earliest.Equal(MinTime) || bounds.Latest.Equal(MaxTime) {
+		return nil, fmt.Errorf("recurring windows need a begin and an end time")
+	}
+
+	days := 0
+	if every%(24*time.Hour) == 0 {
+		days = int(every / (24 * time.Hour))
+	}
+	shift := func(t time.Time, n int) time.Time {
+		if days > 0 {
+			return t.In(loc).AddDate(0, 0, n*days)
+		}
+		return t.Add(time.Duration(n) * every)
+	}
+
+	recurring := []Times{}
+	for _, w := range windows {
+		// Start from the first repeat that doesn't end before bounds.
+		n := int(bounds.Earliest.Sub(w.Latest) / every)
+		for !shift(w.Latest, n-1).Before(bounds.Earliest) {
+			n--
+		}
+		for shift(w.Latest, n).Before(bounds.Earliest) {
+			n++
+		}
+		for ; ; n++ {
+			r := Times{Earliest: shift(w.Earliest, n), Latest: shift(w.Latest, n)}
+			if r.Earliest.After(bounds.Latest) {
+				break
+			}
+			if r.Earliest.Before(bounds.Earliest) {
+				r.Earliest = bounds.Earliest
+			}
+			if r.Latest.After(bounds.Latest) {
+				r.Latest = bounds.Latest
+			}
+			if len(recurring) == maxWindows {
+				return nil, fmt.Errorf("more than %d recurring windows", maxWindows)
+			}
+			recurring = append(recurring, r)
+		}
+	}
+
+	SortWindows(recurring)
+	return recurring, nil
+}
+
+// SortWindows sorts windows by their begin time.
+func SortWindows(windows []Times) {
+	sort.SliceStable(windows, func(i, j int) bool {
+		return windows[i].Earliest.Before(windows[j].Earliest)
+	})
+}
@@ -0,0 +1,42 @@
+package time
+
+import (
+	"time"
+)
+
+/*
+  Times are kept in UTC: in the indexes, and in everything read back from
+  them. Data files whose timestamps have no zone are read in their source's
+  zone, and times are only shown in another zone on output.
+*/
+
+// LoadLocation returns the time zone with the given name: "" or "UTC" for
+// UTC, "Local" for the system's zone, or else an IANA name such as
+// "America/Los_Angeles".
+func LoadLocation(name string) (*time.Location, error) {
+	switch name {
+	case "", "UTC", "utc":
+		return time.UTC, nil
+	case "Local", "local":
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// Format formats t as RFC 3339, with nanoseconds, in loc (UTC if nil).
+func Format(t time.Time, loc *time.Location) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format(time.RFC3339Nano)
+}
+
+// In returns the period with both its times in loc.
+func (tm Times) In(loc *time.Location) Times {
+	return Times{Earliest: tm.Earliest.In(loc), Latest: tm.Latest.In(loc)}
+}
+
+// UTC returns the period with both its times in UTC.
+func (tm Times) UTC() Times {
+	return tm.In(time.UTC)
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"timefind/config"
 	"timefind/index"
+	"timefind/processor"
 	tf_time "timefind/time"
 
 	"github.com/pborman/getopt"
@@ -23,12 +27,31 @@ var beginTimestamp string
 var endTimestamp string
 var listTimes bool = false
 var humanTimes bool = false
-
-var timeLayouts = []string{
-	time.RFC3339Nano,
-	time.RFC3339,
-	"2006-01-02",
-}
+var extractDir string
+var showStats bool = false
+var showSummary bool = false
+var showOffsets bool = false
+var command string // "extract" or "cat", or empty to list paths
+var outputPath string
+var stageDir string
+var stageMode string = "copy"
+var stageJobs int = 4
+var execCommand string
+var execBatchCommand string
+var execParallel int = 1
+var showGaps bool = false
+var minGap time.Duration = time.Minute
+var jsonOutput bool = false
+var atTimestamp string
+var lastDuration string
+var windowDuration string
+var timeZone string
+var windowArgs []string = []string{}
+var windowsFile string
+var everyDuration string
+var matchWithin bool = false
+var matchCovering bool = false
+var allSources bool = false
 
 func vlog(format string, a ...interface{}) {
 	if verbose {
@@ -36,38 +59,6 @@ func vlog(format string, a ...interface{}) {
 	}
 }
 
-func parseTime(timestr string) (time.Time, error) {
-	if len(timestr) == 0 {
-		// returns "0001-01-01 00:00:00 +0000 UTC"
-		return time.Time{}, nil
-	}
-
-	for _, layout := range timeLayouts {
-		if t, err := time.Parse(layout, timestr); err == nil {
-			return t, nil
-		}
-	}
-
-	// time.Parse doesn't have a Unix timestamp layout
-	t := strings.Split(timestr, ".")
-	sec, err := strconv.ParseInt(t[0], 10, 64)
-
-	// if we can't parse nanoseconds, it defaults to 0, which is fine
-	if err == nil {
-		nsec := int64(0)
-
-		if len(t) == 2 {
-			nsec, _ = strconv.ParseInt(t[1], 10, 64)
-		}
-
-		return time.Unix(sec, nsec), nil
-	}
-
-	// exhausted all ways to interpret input timestamps
-	err = fmt.Errorf("could not parse timestamp; check format: %q", timestr)
-	return time.Time{}, err
-}
-
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
@@ -76,10 +67,65 @@ func main() {
 	getopt.BoolVarLong(&verbose, "verbose", 'v', "Verbose progress indicators and messages")
 	getopt.StringVarLong(&beginTimestamp, "begin", 'b', "Begin interval at timestamp", "TIMESTAMP")
 	getopt.StringVarLong(&endTimestamp, "end", 'e', "End interval at timestamp", "TIMESTAMP")
+	getopt.StringVarLong(&lastDuration, "last", 'L',
+		"Find the DURATION up to the end timestamp (or now), e.g., 90m or 2d", "DURATION")
+	getopt.StringVarLong(&windowDuration, "duration", 'd',
+		"End interval DURATION after the begin timestamp", "DURATION")
+	getopt.StringVarLong(&timeZone, "tz", 'z',
+		"Time zone for timestamps without one, and for output times, e.g., America/Los_Angeles or Local (default UTC)", "ZONE")
+	getopt.ListVarLong(&windowArgs, "window", 'w',
+		"Search WINDOW: BEGIN/END, TIME~DURATION or TIME (can be used multiple times)", "WINDOW")
+	getopt.StringVarLong(&windowsFile, "windows", 'F',
+		"Search the windows in FILE, one per line", "FILE")
+	getopt.StringVarLong(&everyDuration, "every", 'E',
+		"Repeat the windows every DURATION between the begin and end timestamps", "DURATION")
+	getopt.StringVarLong(&atTimestamp, "at", 'a',
+		"Find the files containing the instant TIMESTAMP", "TIMESTAMP")
+	getopt.BoolVarLong(&matchWithin, "within", 'W',
+		"Only find files entirely inside the interval")
+	getopt.BoolVarLong(&allSources, "all", 'A', "Search every source on $TIMEFIND_CONFIG_PATH")
+	getopt.BoolVarLong(&matchCovering, "covering", 'C',
+		"Only find files that contain the whole interval")
 	getopt.BoolVarLong(&listTimes, "times", 't', "Output the start and end time for each path")
 	getopt.BoolVarLong(&humanTimes, "human", 'T', "Output human-readable start and end time for each path")
+	getopt.StringVarLong(&extractDir, "extract", 'x',
+		"Extract matching archive members into DIR and output their new paths", "DIR")
+	getopt.BoolVarLong(&showStats, "stats", 's',
+		"Output the number of parsed, skipped and failed records for each path")
+	getopt.BoolVarLong(&showOffsets, "offsets", 'o',
+		"Output the offset in each (decompressed) file to start reading at for the begin time")
+	getopt.BoolVarLong(&showSummary, "summary", 'S',
+		"Output the number and total size of matching files instead of their paths")
+
+	getopt.BoolVarLong(&showGaps, "gaps", 'g',
+		"Output how much of the interval each source covers, and the gaps in it")
+	getopt.DurationVarLong(&minGap, "min-gap", 'G',
+		"With --gaps, only report gaps longer than DURATION (default 1m)", "DURATION")
+	getopt.BoolVarLong(&jsonOutput, "json", 'J', "With --gaps, output JSON")
+	getopt.StringVarLong(&stageDir, "stage", 'D',
+		"Copy or link the matching files into DIR, and output their new paths", "DIR")
+	getopt.StringVarLong(&stageMode, "mode", 'm',
+		"With --stage, how to stage files: copy (default), hardlink, symlink or reflink", "MODE")
+	getopt.IntVarLong(&stageJobs, "jobs", 'j',
+		"With --stage, the number of files to stage at once (default 4)", "N")
+	getopt.StringVarLong(&execCommand, "exec", 'X',
+		"Run COMMAND for each matching file instead of outputting its path", "COMMAND")
+	getopt.StringVarLong(&execBatchCommand, "exec-batch", 'B',
+		"Run COMMAND for batches of matching files instead of outputting their paths", "COMMAND")
+	getopt.IntVarLong(&execParallel, "parallel", 'P',
+		"With --exec or --exec-batch, the number of commands to run at once (default 1)", "N")
+	getopt.StringVarLong(&outputPath, "output", 'O',
+		"With extract or cat, write to FILE instead of standard output", "FILE")
 	help := getopt.BoolLong("help", 'h', "Show this help message and exit")
 
+	// "timefind extract ..." outputs the records in the interval instead
+	// of the paths of the files holding them, and "timefind cat ..." the
+	// whole contents of those files.
+	if len(os.Args) > 1 && (os.Args[1] == "extract" || os.Args[1] == "cat") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	getopt.SetParameters("SOURCE [SOURCE ...]")
 	getopt.Parse()
 
@@ -87,12 +133,30 @@ func main() {
 		getopt.PrintUsage(os.Stderr)
 		fmt.Fprintf(os.Stderr,
 			`
+timefind extract [OPTIONS] SOURCE [SOURCE ...] outputs the records between
+the begin and end times instead, merged in time order.
+
+timefind cat [OPTIONS] SOURCE [SOURCE ...] outputs the decompressed contents of
+the matching files, merged in time order.
+
+SOURCE is the name of a source, NAME.conf.json in one of the directories of
+$TIMEFIND_CONFIG_PATH (default .), a glob pattern of names (e.g., 'dns*'), a
+group of sources defined in a timefind-groups file there (e.g.,
+"netsec = pcap,dns,netflow"), or the path of a configuration file. With more
+than one source, each path is output after the name of its source.
+
 TIMESTAMP must be in one of the following formats:
 
  RFC3339Nano	e.g., 2006-01-02T15:04:05.999999999-07:00
  RFC3339	e.g., 2006-01-02T15:04:05-07:00
- YYYY-MM-DD	e.g., 2006-01-02
+ Local time	e.g., 2006-01-02 15:04:05, 2006-01-02T15:04, 2006-01-02
+		(in the --tz zone, UTC by default)
  Unix time	e.g., 1445471780, 1234471780.372802000
+ Relative	now, today or yesterday, optionally +/- a DURATION,
+		e.g., now-2h, today+9h
+
+DURATION is as in Go (e.g., 90m, 1h30m), optionally after weeks and days
+(e.g., 1w, 2d, 1d12h).
 
 `)
 	})
@@ -102,90 +166,502 @@ TIMESTAMP must be in one of the following formats:
 		os.Exit(0)
 	}
 
-	// take a combination of configPaths (filenames)
-	// and sources (append .conf.json to get a configPath)
-	if len(getopt.Args())+len(configPath) == 0 {
-		getopt.Usage()
-		os.Exit(1)
-	}
-
-	// normalize all non-flag command-line arguments and configPaths.
-	// since we're now reading single source configuration files, we
-	// specifically take as input one or more .conf.json files.
+	// Sources are named on the command line (see sources.go), or given as
+	// configuration files with --config.
 	//
 	// example: timefind dns-data
-	//	=> will look for dns-data.conf.json
+	//	=> will look for dns-data.conf.json on $TIMEFIND_CONFIG_PATH
 	//
 	// example: timefind -c dns-data.conf.json
 	//
-	// XXX no support for wildcards
+	if len(getopt.Args())+len(configPath) == 0 && !allSources {
+		getopt.Usage()
+		os.Exit(1)
+	}
+	resolver, err := newSourceResolver(os.Getenv("TIMEFIND_CONFIG_PATH"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sources, err := resolver.sources(getopt.Args(), configPath, allSources)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(sources) == 0 {
+		log.Fatal("no sources to search")
+	}
+	vlog("looking for configuration files = %+v\n", sources)
+
+	configs := make([]*config.Configuration, len(sources))
+	for i, filename := range sources {
+		if configs[i], err = config.NewConfiguration(filename); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// With more than one source, each path is labelled with its source's
+	// name:
 	//
-	sources := append(getopt.Args(), configPath...)
-	for i, s := range sources {
-		if !strings.HasSuffix(s, ".conf.json") {
-			sources[i] = fmt.Sprintf("%s%s", s, ".conf.json")
+	//	dns     /a/b/c/d.gz
+	//	netflow /a/d/e/f.csv
+	labelWidth := 0
+	if len(configs) > 1 {
+		for _, cfg := range configs {
+			if n := len(cfg.Name); n > labelWidth {
+				labelWidth = n
+			}
 		}
 	}
-	vlog("looking for configuration files = %+v\n", sources)
 
-	/* TODO handle multiple sections with a delimeter or just list the files?
+	// Without a begin (end) time, the interval reaches back (forward) to
+	// the earliest (latest) time there is.
+	if atTimestamp != "" {
+		if beginTimestamp != "" || endTimestamp != "" {
+			log.Fatal("--at can't be used with --begin or --end")
+		}
+		beginTimestamp, endTimestamp = atTimestamp, atTimestamp
+	}
+	query := tf_time.Query{
+		Begin:    beginTimestamp,
+		End:      endTimestamp,
+		Last:     lastDuration,
+		Duration: windowDuration,
+		Windows:  windowArgs,
+		Every:    everyDuration,
+	}
+	if windowsFile != "" {
+		lines, err := readWindows(windowsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		query.Windows = append(query.Windows, lines...)
+	}
+	// Times are parsed, and shown, in the --tz zone.
+	loc, err := tf_time.LoadLocation(timeZone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	query.Location = loc
+	windows, err := query.TimeWindows()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(windows) == 0 {
+		log.Fatal("no time windows to search")
+	}
 
-	option #1 (currently):
+	// The span of all the windows.
+	earliest, latest := windows[0].Earliest, windows[0].Latest
+	for _, window := range windows {
+		if window.Latest.After(latest) {
+			latest = window.Latest
+		}
+	}
+	if len(windows) > 1 && (command == "extract" || showGaps) {
+		log.Fatal("extract and --gaps need a single time window")
+	}
 
-		/a/b/c/d.gz
-		/a/d/e/f.csv
+	match := index.MatchOverlaps
+	if matchWithin && matchCovering {
+		log.Fatal("only one of --within and --covering can be given")
+	} else if matchWithin {
+		match = index.MatchWithin
+	} else if matchCovering {
+		match = index.MatchCovering
+	}
 
-	option #2:
-		dns      /a/b/c/d.gz
-		netflow  /a/d/e/f.csv
-	*/
+	total := summary{name: "total"}
+	gaps := []gapReport{}
+	extract := extraction{}
 
-	for _, config_path := range sources {
+	if err := checkModes(); err != nil {
+		log.Fatal(err)
+	}
 
-		cfg, err := config.NewConfiguration(config_path)
+	var run *executor
+	if execCommand != "" || execBatchCommand != "" {
+		var err error
+		if execCommand != "" {
+			run, err = newExecutor(execCommand, false, execParallel)
+		} else {
+			run, err = newExecutor(execBatchCommand, true, execParallel)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
+	}
+
+	var stage *stager
+	if stageDir != "" {
+		if stage, err = newStager(stageDir, stageMode, stageJobs); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, cfg := range configs {
+
+		// Keep timefind_indexer from replacing the index while we read it.
+		lock, err := index.LockSnapshot(cfg)
+		if err != nil {
+			vlog("not locking index: %s", err)
+		}
 
 		idx, err := index.NewIndex(cfg)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		earliest, err := parseTime(beginTimestamp)
+		vlog("searching for files with timestamp begin: %s, end: %s, in %d windows\n",
+			earliest, latest, len(windows))
+
+		// Recursively find matching logs in the index within the index tree
+		entries, matched := idx.FindWindows(windows, match)
+		lock.Unlock()
+
+		if command != "" {
+			if err := extract.add(cfg, entries, earliest, latest); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+
+		if showGaps {
+			gaps = append(gaps, newGapReport(cfg.Name, entries,
+				tf_time.Times{Earliest: earliest, Latest: latest},
+				earliest.Equal(tf_time.MinTime), latest.Equal(tf_time.MaxTime), minGap, loc))
+			continue
+		}
+
+		if stage != nil {
+			if err := stage.add(cfg, entries, windows, matched); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+
+		if showSummary {
+			summary := newSummary(cfg.Name, entries)
+			fmt.Println(summary)
+			total.add(summary)
+			continue
+		}
+
+		for i, entry := range entries {
+			if _, _, ok := processor.SplitArchivePath(entry.Path); ok && extractDir != "" {
+				path, err := processor.ExtractMember(entry.Path, extractDir)
+				if err != nil {
+					log.Fatal(err)
+				}
+				entry.Path = path
+			}
+
+			if run != nil {
+				run.add(cfg.Name, entry)
+				continue
+			}
+
+			fields := []string{entry.Path}
+			if labelWidth > 0 {
+				fields = []string{fmt.Sprintf("%-*s", labelWidth, cfg.Name), entry.Path}
+			}
+			if humanTimes {
+				fields = append(fields, tf_time.Format(entry.Period.Earliest, loc),
+					tf_time.Format(entry.Period.Latest, loc))
+			} else if listTimes {
+				earliest, _ := tf_time.MarshalTime(entry.Period.Earliest)
+				latest, _ := tf_time.MarshalTime(entry.Period.Latest)
+				fields = append(fields, string(earliest), string(latest))
+			}
+			if showOffsets {
+				// From the start of the first window it matched.
+				offset, err := index.SeekOffset(cfg, entry, windows[matched[i][0]].Earliest)
+				if err != nil {
+					log.Fatal(err)
+				}
+				fields = append(fields, strconv.FormatInt(offset, 10))
+			}
+			if showStats {
+				fields = append(fields,
+					strconv.FormatInt(entry.Stats.Parsed, 10),
+					strconv.FormatInt(entry.Stats.Skipped, 10),
+					strconv.FormatInt(entry.Stats.Failed, 10))
+			}
+			if len(windows) > 1 {
+				fields = append(fields, formatWindows(windows, matched[i], loc))
+			}
+			fmt.Println(strings.Join(fields, " "))
+		}
+
+		if showStats {
+			total := processor.Stats{}
+			for _, entry := range entries {
+				total.Sum(entry.Stats)
+			}
+			fmt.Fprintf(os.Stderr, "%s: %d files, %d records parsed, %d skipped, %d failed\n",
+				cfg.Name, len(entries), total.Parsed, total.Skipped, total.Failed)
+		}
+	}
+
+	if showSummary && len(sources) > 1 {
+		fmt.Println(total)
+	}
+
+	if showGaps && jsonOutput {
+		out, err := json.MarshalIndent(gaps, "", "  ")
 		if err != nil {
 			log.Fatal(err)
 		}
-		latest, err := parseTime(endTimestamp)
+		fmt.Println(string(out))
+	} else if showGaps {
+		for _, r := range gaps {
+			fmt.Println(r)
+		}
+	}
+
+	if stage != nil {
+		err := stage.run(earliest, latest)
+		for _, item := range stage.items {
+			if item.status != "failed" {
+				fmt.Println(filepath.Join(stageDir, item.dest))
+			}
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
+	}
 
-		// if we have no endTimestamp, set latest to the max time
-		if latest == (time.Time{}) {
-			vlog("setting endTimestamp to MaxInt32")
-			latest = time.Unix(math.MaxInt32, 0)
+	if command != "" {
+		if err := extract.write(outputPath); err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		vlog("searching for files with timestamp begin: %s, end: %s\n", earliest, latest)
+	if run != nil {
+		if status := run.finish(); status != 0 {
+			os.Exit(status)
+		}
+	}
+}
 
-		// Recursively find matching logs in the index within the index tree
-		for _, entry := range idx.FindLogs(earliest, latest) {
-			if listTimes || humanTimes {
-				if humanTimes {
-					earliest, _ := (entry.Period.Earliest).MarshalText()
-					latest, _ := (entry.Period.Latest).MarshalText()
-					fmt.Printf("%s %s %s\n", entry.Path, earliest, latest)
-				} else {
-					earliest, _ := tf_time.MarshalTime(entry.Period.Earliest)
-					latest, _ := tf_time.MarshalTime(entry.Period.Latest)
-					fmt.Printf("%s %s %s\n", entry.Path, earliest, latest)
-				}
-			} else {
-				fmt.Println(entry.Path)
+// checkModes returns an error if more than one of the modes that output
+// something other than the list of paths was asked for, or if --exec or
+// --exec-batch was given with options that only change that list.
+func checkModes() error {
+	modes := []string{}
+	for _, mode := range []struct {
+		name string
+		set  bool
+	}{
+		{command, command != ""},
+		{"--gaps", showGaps},
+		{"--stage", stageDir != ""},
+		{"--summary", showSummary},
+		{"--exec", execCommand != ""},
+		{"--exec-batch", execBatchCommand != ""},
+	} {
+		if mode.set {
+			modes = append(modes, mode.name)
+		}
+	}
+	if len(modes) > 1 {
+		return fmt.Errorf("only one of extract, cat, --gaps, --stage, --summary, --exec and --exec-batch can be given, not %s",
+			strings.Join(modes, " and "))
+	}
+
+	if execCommand != "" || execBatchCommand != "" {
+		for _, opt := range []struct {
+			name string
+			set  bool
+		}{
+			{"--times", listTimes},
+			{"--human", humanTimes},
+			{"--offsets", showOffsets},
+			{"--stats", showStats},
+		} {
+			if opt.set {
+				return fmt.Errorf("%s doesn't apply to --exec or --exec-batch, which output no paths", opt.name)
 			}
 		}
 	}
+	return nil
+}
+
+// readWindows reads time windows from filename, one per line. Blank lines and
+// lines starting with "#" are skipped.
+func readWindows(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	windows := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		windows = append(windows, line)
+	}
+	return windows, scanner.Err()
+}
+
+// formatWindows formats the windows with the given indexes as
+// "BEGIN/END,BEGIN/END,...", in loc.
+func formatWindows(windows []tf_time.Times, indexes []int, loc *time.Location) string {
+	formatted := make([]string, len(indexes))
+	for i, n := range indexes {
+		formatted[i] = tf_time.Format(windows[n].Earliest, loc) + "/" +
+			tf_time.Format(windows[n].Latest, loc)
+	}
+	return strings.Join(formatted, ",")
+}
+
+// formatUnixTime returns t as a Unix time, for {earliest} and {latest} and
+// the stage manifest: empty if t is zero (the period of a file with no
+// records) or an open end of the query, MinTime or MaxTime.
+func formatUnixTime(t time.Time) string {
+	if t.IsZero() || t.Equal(tf_time.MinTime) || t.Equal(tf_time.MaxTime) {
+		return ""
+	}
+	m, _ := tf_time.MarshalTime(t)
+	return string(m)
+}
+
+// An extraction collects the data files to extract records from (or cat),
+// across all the sources.
+type extraction struct {
+	processor processor.Processor
+	typ       string
+	opts      processor.Options
+	loc       *time.Location // Of times without a zone in the files
+	files     []processor.ExtractFile
+	earliest  time.Time
+	latest    time.Time
+}
+
+func (x *extraction) add(cfg *config.Configuration, entries []index.Entry,
+	earliest time.Time, latest time.Time) error {
+
+	p, ok := processor.Lookup(cfg.Type)
+	if !ok {
+		return fmt.Errorf("%s: can't %s records of type %s, which this timefind wasn't built with",
+			cfg.Name, command, cfg.Type)
+	}
+	if _, ok := p.(processor.Extractor); !ok && command == "extract" {
+		return fmt.Errorf("%s: can't extract records of type %s", cfg.Name, cfg.Type)
+	}
+	if x.processor != nil && cfg.Type != x.typ {
+		return fmt.Errorf("%s: can't %s records of type %s together with type %s",
+			cfg.Name, command, cfg.Type, x.typ)
+	}
+	loc, err := index.SourceLocation(cfg)
+	if err != nil {
+		return err
+	}
+	if x.processor != nil && loc.String() != x.loc.String() {
+		return fmt.Errorf("%s: can't %s records in time zone %s together with time zone %s",
+			cfg.Name, command, loc, x.loc)
+	}
+	x.processor, x.typ, x.opts, x.loc = p, cfg.Type, processor.Options(cfg.Options), loc
+	x.earliest, x.latest = earliest, latest
+
+	for _, entry := range entries {
+		offset := int64(0)
+		if command == "extract" {
+			if offset, err = index.SeekOffset(cfg, entry, earliest); err != nil {
+				return err
+			}
+		}
+		x.files = append(x.files, processor.ExtractFile{
+			Filename: entry.Path,
+			Offset:   offset,
+			Earliest: entry.Period.Earliest,
+			Latest:   entry.Period.Latest,
+		})
+	}
+	return nil
+}
+
+// write writes the records to path, or standard output if path is empty.
+func (x *extraction) write(path string) error {
+	if x.processor == nil {
+		return nil
+	}
+	if path == "" {
+		return x.writeTo(os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := x.writeTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (x *extraction) writeTo(w io.Writer) error {
+	if command == "cat" {
+		return processor.Cat(w, x.processor, x.opts, x.loc, x.files)
+	}
+	return processor.Extract(w, x.processor.(processor.Extractor), x.opts, x.loc, x.files,
+		x.earliest, x.latest)
+}
+
+// A summary describes the data files that matched a query, from the index
+// alone.
+type summary struct {
+	name         string
+	files        int
+	size         int64 // On disk, counting each archive once
+	uncompressed int64
+	unknown      int // Files whose uncompressed size isn't known
+}
+
+func newSummary(name string, entries []index.Entry) summary {
+	s := summary{name: name, files: len(entries)}
+	s.size, s.uncompressed = index.SumSizes(entries)
+	for _, entry := range entries {
+		if entry.Uncompressed == 0 && entry.Size > 0 {
+			s.unknown++
+		}
+	}
+	return s
+}
+
+func (s *summary) add(other summary) {
+	s.files += other.files
+	s.size += other.size
+	s.uncompressed += other.uncompressed
+	s.unknown += other.unknown
+}
+
+func (s summary) String() string {
+	str := fmt.Sprintf("%s: %d files, %d bytes (%s), %d bytes uncompressed (%s)",
+		s.name, s.files, s.size, humanBytes(s.size),
+		s.uncompressed, humanBytes(s.uncompressed))
+	if s.unknown > 0 {
+		str += fmt.Sprintf(", not counting %d files of unknown uncompressed size", s.unknown)
+	}
+	return str
+}
+
+// humanBytes formats n with a binary unit, e.g., "1.5 GiB".
+func humanBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	i := -1
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", f, units[i])
 }
 
 // vim: noet:ts=4:sw=4:tw=80
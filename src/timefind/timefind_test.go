@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"timefind/index"
+)
+
+func TestSummary(t *testing.T) {
+	type source struct {
+		name    string
+		entries []index.Entry
+	}
+	tests := []struct {
+		name    string
+		sources []source
+		want    []string // One line per source, and the total for several
+	}{
+		{
+			name:    "empty source",
+			sources: []source{{"dns", nil}},
+			want:    []string{"dns: 0 files, 0 bytes (0 B), 0 bytes uncompressed (0 B)"},
+		},
+		{
+			name: "one file",
+			sources: []source{{"dns", []index.Entry{
+				{Path: "/data/a.gz", Size: 2048, Uncompressed: 4096},
+			}}},
+			want: []string{"dns: 1 files, 2048 bytes (2.0 KiB), 4096 bytes uncompressed (4.0 KiB)"},
+		},
+		{
+			name: "several sources",
+			sources: []source{
+				{"dns", []index.Entry{
+					// Members of one archive count its size once.
+					{Path: "/data/a.tar!x.log", Size: 1000, Uncompressed: 3000},
+					{Path: "/data/a.tar!y.log", Size: 1000, Uncompressed: 3000},
+					{Path: "/data/b.xz", Size: 500},
+				}},
+				{"netflow", nil},
+				{"pcap", []index.Entry{
+					{Path: "/data/c.pcap", Size: 1 << 20, Uncompressed: 1 << 20},
+				}},
+			},
+			want: []string{
+				"dns: 3 files, 1500 bytes (1.5 KiB), 6000 bytes uncompressed (5.9 KiB), " +
+					"not counting 1 files of unknown uncompressed size",
+				"netflow: 0 files, 0 bytes (0 B), 0 bytes uncompressed (0 B)",
+				"pcap: 1 files, 1048576 bytes (1.0 MiB), 1048576 bytes uncompressed (1.0 MiB)",
+				"total: 4 files, 1050076 bytes (1.0 MiB), 1054576 bytes uncompressed (1.0 MiB), " +
+					"not counting 1 files of unknown uncompressed size",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		total := summary{name: "total"}
+		got := []string{}
+		for _, s := range test.sources {
+			summary := newSummary(s.name, s.entries)
+			got = append(got, summary.String())
+			total.add(summary)
+		}
+		if len(test.sources) > 1 {
+			got = append(got, total.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got\n%q\nwant\n%q", test.name, got, test.want)
+		}
+	}
+}